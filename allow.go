@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+)
+
+var webAllow = flag.String("web.allow", "", "Comma separated CIDRs or addresses allowed to connect to the HTTP server, like munin-node's cidr_allow. Everyone if empty.")
+
+// allowListener wraps l to close connections from addresses outside of
+// -web.allow right after accepting them.
+func allowListener(l net.Listener) (net.Listener, error) {
+	if *webAllow == "" {
+		return l, nil
+	}
+	nets, err := parseCIDRs(*webAllow)
+	if err != nil {
+		return nil, err
+	}
+	return &allowlistListener{Listener: l, nets: nets}, nil
+}
+
+type allowlistListener struct {
+	net.Listener
+	nets []*net.IPNet
+}
+
+func (l *allowlistListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if ok && containsIP(l.nets, addr.IP) {
+			return conn, nil
+		}
+		log.Printf("Denying connection from %s", conn.RemoteAddr())
+		conn.Close()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	webAuth              = flag.String("web.auth", "none", "Comma separated authentication methods all HTTP requests must pass: none, basic, token, mtls, ip.")
+	webAuthBasicUser     = flag.String("web.auth.basic-user", "", "User name for basic authentication.")
+	webAuthBasicPassword = flag.String("web.auth.basic-password-file", "", "File holding the password for basic authentication.")
+	webAuthTokenFile     = flag.String("web.auth.token-file", "", "File holding the bearer token for token authentication.")
+	webAuthAllow         = flag.String("web.auth.allow", "", "Comma separated CIDRs allowed by ip authentication.")
+	webTLSCertFile       = flag.String("web.tls.cert-file", "", "Certificate to serve HTTPS with. Plain HTTP if empty.")
+	webTLSKeyFile        = flag.String("web.tls.key-file", "", "Private key for -web.tls.cert-file.")
+	webTLSClientCAFile   = flag.String("web.tls.client-ca-file", "", "CA to verify client certificates against, required by mtls authentication.")
+)
+
+// authenticator decides whether an HTTP request may be served.
+type authenticator interface {
+	authenticate(r *http.Request) error
+}
+
+type authenticatorFunc func(r *http.Request) error
+
+func (f authenticatorFunc) authenticate(r *http.Request) error { return f(r) }
+
+// authenticators maps the names accepted by -web.auth to constructors.
+// Embedders can add their own methods here before main runs.
+var authenticators = map[string]func() (authenticator, error){
+	"none":  func() (authenticator, error) { return authenticatorFunc(func(*http.Request) error { return nil }), nil },
+	"basic": newBasicAuthenticator,
+	"token": newTokenAuthenticator,
+	"mtls":  newMTLSAuthenticator,
+	"ip":    newIPAuthenticator,
+}
+
+// withAuth wraps handler so every request has to pass all authentication
+// methods configured with -web.auth.
+func withAuth(handler http.Handler) (http.Handler, error) {
+	var chain []authenticator
+	for _, name := range strings.Split(*webAuth, ",") {
+		name = strings.TrimSpace(name)
+		newAuthenticator, ok := authenticators[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown authentication method %q", name)
+		}
+		a, err := newAuthenticator()
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't set up %s authentication: %s", name, err)
+		}
+		chain = append(chain, a)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range chain {
+			if err := a.authenticate(r); err != nil {
+				log.Printf("Rejected %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, err)
+				if _, ok := err.(basicAuthError); ok {
+					w.Header().Set("WWW-Authenticate", `Basic realm="munin_exporter"`)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+type basicAuthError struct{ error }
+
+func newBasicAuthenticator() (authenticator, error) {
+	password, err := ioutil.ReadFile(*webAuthBasicPassword)
+	if err != nil {
+		return nil, err
+	}
+	want := []byte(*webAuthBasicUser + ":" + strings.TrimSpace(string(password)))
+	return authenticatorFunc(func(r *http.Request) error {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user+":"+password), want) != 1 {
+			return basicAuthError{errors.New("invalid basic auth credentials")}
+		}
+		return nil
+	}), nil
+}
+
+func newTokenAuthenticator() (authenticator, error) {
+	token, err := ioutil.ReadFile(*webAuthTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	want := []byte("Bearer " + strings.TrimSpace(string(token)))
+	return authenticatorFunc(func(r *http.Request) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			return errors.New("invalid bearer token")
+		}
+		return nil
+	}), nil
+}
+
+func newMTLSAuthenticator() (authenticator, error) {
+	if *webTLSClientCAFile == "" {
+		return nil, errors.New("-web.tls.client-ca-file is required")
+	}
+	return authenticatorFunc(func(r *http.Request) error {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		return nil
+	}), nil
+}
+
+func newIPAuthenticator() (authenticator, error) {
+	nets, err := parseCIDRs(*webAuthAllow)
+	if err != nil {
+		return nil, err
+	}
+	return authenticatorFunc(func(r *http.Request) error {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return err
+		}
+		if !containsIP(nets, net.ParseIP(host)) {
+			return fmt.Errorf("%s not in allowed networks", host)
+		}
+		return nil
+	}), nil
+}
+
+// parseCIDRs parses a comma separated list of CIDRs. Plain addresses are
+// taken as single host networks.
+func parseCIDRs(list string) (nets []*net.IPNet, err error) {
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid address %q", cidr)
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// webTLSConfig returns the TLS configuration for the HTTP server, asking
+// for client certificates if a client CA is configured.
+func webTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if *webTLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(*webTLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", *webTLSClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	canary              = flag.Bool("canary", false, "Mark the target as canary: failed scrapes are retried after -canary.retry-interval and reported as munin_canary_up.")
+	canaryRetryInterval = flag.Duration("canary.retry-interval", 5*time.Second, "Time to wait before retrying a failed scrape of a canary target.")
+
+	canaryUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_canary_up",
+			Help: "Whether the last scrape of the canary target succeeded.",
+		},
+		[]string{"hostname"},
+	)
+)
+
+func init() {
+	registry.MustRegister(canaryUp)
+}
+
+// nextScrape records the outcome of a scrape of a canary target and
+// returns how long to wait for the next scrape.
+func nextScrape(err error) time.Duration {
+	interval := time.Duration(*muninScrapeInterval) * time.Second
+	if !*canary {
+		return interval
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+		if *canaryRetryInterval < interval {
+			interval = *canaryRetryInterval
+		}
+	}
+	canaryUp.Reset()
+	for _, identity := range identities() {
+		canaryUp.WithLabelValues(identity).Set(up)
+	}
+	return interval
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// wantedCaps are the capabilities the exporter asks munin-node for.
+var wantedCaps = []string{"multigraph", "dirtyconfig", "spool"}
+
+var (
+	nodeCaps   = map[string]bool{}
+	nodeCapsMu sync.RWMutex
+)
+
+// recordCaps records which of the capabilities the exporter announced on a
+// new connection munin-node supports, so features can be enabled only when
+// the node can handle them.
+func recordCaps(caps []string) {
+	nodeCapsMu.Lock()
+	defer nodeCapsMu.Unlock()
+	nodeCaps = map[string]bool{}
+	for _, capability := range caps {
+		nodeCaps[capability] = true
+	}
+	log.Printf("munin-node capabilities: %s", strings.Join(caps, " "))
+}
+
+// hasCap reports whether munin-node supports capability.
+func hasCap(capability string) bool {
+	nodeCapsMu.RLock()
+	defer nodeCapsMu.RUnlock()
+	return nodeCaps[capability]
+}
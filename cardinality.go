@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var cardinalityTop = 10
+
+// parseCardinalityArgs applies the flags of the cardinality subcommand:
+// --target overrides -muninAddress and --top sets how many plugins are
+// highlighted.
+func parseCardinalityArgs(args []string) {
+	fs := flag.NewFlagSet("cardinality", flag.ExitOnError)
+	target := fs.String("target", "", "munin-node address to report on, overriding -muninAddress.")
+	fs.IntVar(&cardinalityTop, "top", cardinalityTop, "Number of plugins with the most series to highlight.")
+	fs.Parse(args)
+	if *target != "" {
+		*muninAddress = *target
+	}
+}
+
+// cardinality runs one scrape and reports how many series each plugin
+// generates, most first, highlighting the top offenders. It returns the
+// exit code.
+func cardinality() int {
+	if err := fetchMetrics(); err != nil {
+		log.Printf("Error occured when trying to fetch metrics: %s", err)
+		return 1
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		log.Printf("Couldn't gather exported metrics: %s", err)
+		return 1
+	}
+
+	series := map[string]int{}
+	total := 0
+	catalogMu.RLock()
+	for _, family := range families {
+		entry, ok := catalog[family.GetName()]
+		if !ok {
+			continue // the exporter's own metrics
+		}
+		series[entry.Plugin] += len(family.GetMetric())
+		total += len(family.GetMetric())
+	}
+	catalogMu.RUnlock()
+
+	plugins := make([]string, 0, len(series))
+	for plugin := range series {
+		plugins = append(plugins, plugin)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		if series[plugins[i]] != series[plugins[j]] {
+			return series[plugins[i]] > series[plugins[j]]
+		}
+		return plugins[i] < plugins[j]
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, " \tPLUGIN\tSERIES\tSHARE\n")
+	for i, plugin := range plugins {
+		mark := ""
+		if i < cardinalityTop {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\n", mark, plugin, series[plugin], 100*float64(series[plugin])/float64(total))
+	}
+	w.Flush()
+	banner, _ := nodeBanner()
+	fmt.Printf("%d series from %d plugins of %s, top %d marked with *\n", total, len(plugins), banner, cardinalityTop)
+	return 0
+}
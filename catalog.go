@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// catalogEntry describes one metric generated from a munin field.
+type catalogEntry struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Help        string            `json:"help"`
+	Labels      []string          `json:"labels"`
+	ConstLabels map[string]string `json:"const_labels"`
+	Unit        string            `json:"unit,omitempty"`
+	Plugin      string            `json:"plugin"`
+	Field       string            `json:"field"` // empty for metrics shared by the fields of a graph
+}
+
+var (
+	catalog   = map[string]catalogEntry{}
+	catalogMu sync.RWMutex
+)
+
+// addCatalogEntry adds the metric name to the catalog. Its labels are
+// hostname, graphname and muninlabel, followed by extraLabels.
+func addCatalogEntry(name, metricType, help string, constLabels map[string]string, plugin, field, unit string, extraLabels ...string) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog[name] = catalogEntry{
+		Name:        name,
+		Type:        metricType,
+		Help:        help,
+		Labels:      append([]string{"hostname", "graphname", "muninlabel"}, extraLabels...),
+		ConstLabels: constLabels,
+		Unit:        unit,
+		Plugin:      plugin,
+		Field:       field,
+	}
+}
+
+// serveCatalog emits every metric the exporter generates, sorted by name,
+// so documentation and validation tooling can be kept in sync.
+func serveCatalog(w http.ResponseWriter, r *http.Request) {
+	catalogMu.RLock()
+	entries := make([]catalogEntry, 0, len(catalog))
+	for _, entry := range catalog {
+		entries = append(entries, entry)
+	}
+	catalogMu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	from, to, err := page(w, r, len(entries))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries = entries[from:to]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Printf("Couldn't write catalog response: %s", err)
+	}
+}
+
+// metadata is an entry of Prometheus' metadata API.
+type metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// serveMetadata emits the type, help and unit of every generated metric like
+// Prometheus' /api/v1/metadata does, so tools browsing metrics can show them
+// for munin-derived metrics.
+func serveMetadata(w http.ResponseWriter, r *http.Request) {
+	data := map[string][]metadata{}
+	catalogMu.RLock()
+	for name, entry := range catalog {
+		data[name] = []metadata{{Type: entry.Type, Help: entry.Help, Unit: entry.Unit}}
+	}
+	catalogMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string                `json:"status"`
+		Data   map[string][]metadata `json:"data"`
+	}{"success", data}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Couldn't write metadata response: %s", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeMetadata(t *testing.T) {
+	catalogMu.Lock()
+	saved := catalog
+	catalog = map[string]catalogEntry{
+		"munin_if_eth0_down": {Name: "munin_if_eth0_down", Type: "counter", Help: "received", Unit: "bytes"},
+		"munin_load_load":    {Name: "munin_load_load", Type: "gauge", Help: "load"},
+	}
+	catalogMu.Unlock()
+	defer func() {
+		catalogMu.Lock()
+		catalog = saved
+		catalogMu.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	serveMetadata(w, httptest.NewRequest("GET", "/api/v1/metadata", nil))
+	var response struct {
+		Status string
+		Data   map[string][]metadata
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]metadata{
+		"munin_if_eth0_down": {{Type: "counter", Help: "received", Unit: "bytes"}},
+		"munin_load_load":    {{Type: "gauge", Help: "load"}},
+	}
+	if response.Status != "success" || !reflect.DeepEqual(response.Data, want) {
+		t.Errorf("metadata = %s %+v, want success %+v", response.Status, response.Data, want)
+	}
+}
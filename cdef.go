@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+// graphCDEFs holds the cdefs of the fields of each graph.
+var graphCDEFs = map[string]map[string]*munin.CDEF{}
+
+// registerCDEFs parses the cdefs of the fields of graph. Invalid ones are
+// logged and ignored.
+func registerCDEFs(graph string, fields map[string]map[string]string) {
+	delete(graphCDEFs, graph)
+	for field, config := range fields {
+		expr, ok := config["cdef"]
+		if !ok {
+			continue
+		}
+		cdef, err := munin.ParseCDEF(expr)
+		if err != nil {
+			log.Printf("Ignoring cdef of %s in %s: %s", field, graph, err)
+			continue
+		}
+		if graphCDEFs[graph] == nil {
+			graphCDEFs[graph] = map[string]*munin.CDEF{}
+		}
+		graphCDEFs[graph][field] = cdef
+	}
+}
@@ -0,0 +1,71 @@
+// Command munin-simulator runs a munin-node serving synthetic plugins, for
+// developing dashboards and testing exporter configurations without a real
+// munin-node.
+//
+// Plugins are declared in a JSON file like
+//
+//	{
+//	  "hostname": "simulated",
+//	  "plugins": {
+//	    "load": {
+//	      "title": "Load average",
+//	      "category": "system",
+//	      "fields": {"load": {"kind": "sine", "min": 0.2, "max": 4, "period": "10m"}}
+//	    },
+//	    "if_eth0": {
+//	      "title": "eth0 traffic",
+//	      "category": "network",
+//	      "vlabel": "bits in (-) / out (+) per second",
+//	      "fields": {
+//	        "down": {"kind": "counter", "rate": 125000},
+//	        "up": {"kind": "counter", "rate": 40000}
+//	      },
+//	      "fail_rate": 0.05,
+//	      "delay": "200ms"
+//	    },
+//	    "users": {"fields": {"logged_in": {"kind": "random_walk", "min": 0, "max": 50, "step": 2}}}
+//	  }
+//	}
+//
+// Field kinds are sine (between min and max over period), counter
+// (a DERIVE increasing by rate per second), random_walk (moving by up to
+// step, within min and max) and constant (value). Failures are injected per
+// plugin: fail_rate is the share of fetches answered without values,
+// unknown_rate the share of values reported as U, malformed_rate the share
+// of values that can't be parsed, and delay slows down every fetch.
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+var (
+	config        = flag.String("config", "", "JSON file declaring the simulated plugins.")
+	listenAddress = flag.String("listen", "localhost:4949", "Address to serve the munin-node protocol on.")
+	seed          = flag.Int64("seed", 0, "Seed for random walks and failure injection. The current time if 0.")
+)
+
+func main() {
+	flag.Parse()
+	sim, err := loadSimulation(*config)
+	if err != nil {
+		log.Fatalf("Could not load simulation: %s", err)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fixture := sim.fixture(rand.New(rand.NewSource(*seed)))
+
+	l, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %s", *listenAddress, err)
+	}
+	log.Printf("Simulating %d plugins of %s on %s", len(fixture.Plugins), fixture.Hostname, l.Addr())
+	log.Fatal(muninmock.NewServer(fixture).Serve(l))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+// simulation declares a simulated munin-node.
+type simulation struct {
+	Hostname string               `json:"hostname"`
+	Version  string               `json:"version"`
+	Caps     []string             `json:"caps"`
+	Plugins  map[string]simPlugin `json:"plugins"`
+}
+
+// simPlugin declares a synthetic plugin and the failures injected into it.
+type simPlugin struct {
+	Title         string              `json:"title"`
+	Category      string              `json:"category"`
+	VLabel        string              `json:"vlabel"`
+	Fields        map[string]simField `json:"fields"`
+	FailRate      float64             `json:"fail_rate"`
+	UnknownRate   float64             `json:"unknown_rate"`
+	MalformedRate float64             `json:"malformed_rate"`
+	Delay         duration            `json:"delay"`
+}
+
+// simField declares how the values of a field evolve.
+type simField struct {
+	Kind   string   `json:"kind"`
+	Min    float64  `json:"min"`
+	Max    float64  `json:"max"`
+	Period duration `json:"period"`
+	Rate   float64  `json:"rate"`
+	Step   float64  `json:"step"`
+	Value  float64  `json:"value"`
+}
+
+// duration is a time.Duration given as a string like "10m" in JSON.
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func loadSimulation(path string) (*simulation, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sim := &simulation{}
+	if err := json.Unmarshal(raw, sim); err != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %s", path, err)
+	}
+	if sim.Hostname == "" {
+		sim.Hostname = "simulated"
+	}
+	if sim.Version == "" {
+		sim.Version = "2.0.49"
+	}
+	for name, p := range sim.Plugins {
+		for field, f := range p.Fields {
+			switch f.Kind {
+			case "sine", "random_walk":
+				if f.Max < f.Min {
+					return nil, fmt.Errorf("%s.%s: max is below min", name, field)
+				}
+			case "counter", "constant":
+			default:
+				return nil, fmt.Errorf("%s.%s: unknown kind %q", name, field, f.Kind)
+			}
+		}
+	}
+	return sim, nil
+}
+
+// fixture returns a muninmock fixture generating the values of the
+// simulated plugins on every fetch.
+func (sim *simulation) fixture(rnd *rand.Rand) *muninmock.Fixture {
+	f := &muninmock.Fixture{
+		Hostname: sim.Hostname,
+		Version:  sim.Version,
+		Caps:     sim.Caps,
+		Nodes:    []string{sim.Hostname},
+		Plugins:  map[string]muninmock.Plugin{},
+	}
+	g := &generator{rnd: rnd, start: time.Now(), walks: map[string]float64{}}
+	for name, p := range sim.Plugins {
+		name, p := name, p
+		f.Plugins[name] = muninmock.Plugin{
+			Config:  p.config(name),
+			Fetcher: func() []string { return g.fetch(name, p) },
+		}
+	}
+	return f
+}
+
+func (p simPlugin) fieldNames() []string {
+	names := make([]string, 0, len(p.Fields))
+	for name := range p.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p simPlugin) config(name string) []string {
+	title := p.Title
+	if title == "" {
+		title = name
+	}
+	lines := []string{"graph_title " + title}
+	if p.Category != "" {
+		lines = append(lines, "graph_category "+p.Category)
+	}
+	if p.VLabel != "" {
+		lines = append(lines, "graph_vlabel "+p.VLabel)
+	}
+	for _, field := range p.fieldNames() {
+		lines = append(lines, field+".label "+field)
+		if p.Fields[field].Kind == "counter" {
+			lines = append(lines, field+".type DERIVE", field+".min 0")
+		}
+	}
+	return lines
+}
+
+// generator produces the values of all simulated plugins.
+type generator struct {
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	start time.Time
+	walks map[string]float64 // current value of each random walk
+}
+
+func (g *generator) fetch(name string, p simPlugin) []string {
+	time.Sleep(p.Delay.Duration)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.rnd.Float64() < p.FailRate {
+		return []string{"# Timed out"}
+	}
+	var lines []string
+	for _, field := range p.fieldNames() {
+		value := strconv.FormatFloat(g.value(name+"."+field, p.Fields[field]), 'f', -1, 64)
+		switch {
+		case g.rnd.Float64() < p.UnknownRate:
+			value = "U"
+		case g.rnd.Float64() < p.MalformedRate:
+			value = "not-a-number"
+		}
+		lines = append(lines, field+".value "+value)
+	}
+	return lines
+}
+
+func (g *generator) value(key string, f simField) float64 {
+	elapsed := time.Since(g.start).Seconds()
+	switch f.Kind {
+	case "sine":
+		period := f.Period.Seconds()
+		if period <= 0 {
+			period = 600
+		}
+		return f.Min + (f.Max-f.Min)*(1+math.Sin(2*math.Pi*elapsed/period))/2
+	case "counter":
+		return math.Floor(f.Rate * elapsed)
+	case "random_walk":
+		v, ok := g.walks[key]
+		if !ok {
+			v = f.Min + (f.Max-f.Min)/2
+		}
+		v += (2*g.rnd.Float64() - 1) * f.Step
+		v = math.Max(f.Min, math.Min(f.Max, v))
+		g.walks[key] = v
+		return v
+	}
+	return f.Value
+}
@@ -0,0 +1,29 @@
+// Command muninmock runs a fake munin-node serving a muninmock fixture.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+var (
+	fixture       = flag.String("fixture", "", "JSON fixture declaring the node's plugins and responses.")
+	listenAddress = flag.String("listen", "localhost:4949", "Address to serve the munin-node protocol on.")
+)
+
+func main() {
+	flag.Parse()
+	f, err := muninmock.LoadFixture(*fixture)
+	if err != nil {
+		log.Fatalf("Could not load fixture: %s", err)
+	}
+	l, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %s", *listenAddress, err)
+	}
+	log.Printf("Serving %s on %s", *fixture, l.Addr())
+	log.Fatal(muninmock.NewServer(f).Serve(l))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// knownCategories are the graph categories recommended by munin; each gets
+// a node_exporter style --collector.<category> / --no-collector.<category>
+// flag pair. Plugins in other categories are always enabled.
+var knownCategories = []string{
+	"1sec", "antivirus", "appserver", "auth", "backup", "chat", "cloud",
+	"cms", "cpu", "db", "devel", "disk", "dns", "filetransfer", "forum",
+	"fs", "fw", "games", "htc", "loadbalancer", "mail", "mailinglist",
+	"memory", "munin", "network", "other", "printing", "processes", "radio",
+	"san", "search", "security", "sensors", "spamfilter", "streaming",
+	"system", "time", "tv", "virtualization", "voip", "webserver", "wiki",
+	"wireless",
+}
+
+type collectorFlag struct {
+	enabled  *bool
+	disabled *bool
+}
+
+var collectorFlags = newCollectorFlags()
+
+func newCollectorFlags() map[string]collectorFlag {
+	flags := map[string]collectorFlag{}
+	for _, category := range knownCategories {
+		flags[category] = collectorFlag{
+			enabled:  flag.Bool("collector."+category, true, fmt.Sprintf("Enable plugins in the %s graph category.", category)),
+			disabled: flag.Bool("no-collector."+category, false, fmt.Sprintf("Disable plugins in the %s graph category.", category)),
+		}
+	}
+	return flags
+}
+
+// graphCategory returns the graph_category of a plugin, which munin
+// defaults to "other".
+func graphCategory(graphConfig map[string]string) string {
+	if category := graphConfig["graph_category"]; category != "" {
+		return strings.ToLower(category)
+	}
+	return "other"
+}
+
+func collectorEnabled(category string) bool {
+	f, ok := collectorFlags[category]
+	if !ok {
+		return true
+	}
+	return *f.enabled && !*f.disabled
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	configFile            = flag.String("config.file", "", "Configuration file or http(s) URL to load it from. Disabled if empty.")
+	configBearerTokenFile = flag.String("config.bearer-token-file", "", "File holding a bearer token to send when fetching the configuration from a URL.")
+	configRefreshInterval = flag.Duration("config.refresh-interval", 5*time.Minute, "Interval for refetching the configuration from a URL, 0 to disable.")
+
+	// settings holds the configuration keys that are not flags.
+	settings   = map[string]string{}
+	settingsMu sync.RWMutex
+	configRaw  []byte
+	// commandLineFlags holds the flags given on the command line, which the
+	// configuration doesn't override.
+	commandLineFlags map[string]bool
+)
+
+// configUpdate is a configuration read from the file, not applied yet.
+type configUpdate struct {
+	raw      []byte
+	flags    map[string]string // the flags not given on the command line
+	settings map[string]string
+}
+
+// loadConfig reads the configuration file, a list of "key = value" lines
+// with # comments, in which values can reference secrets. Keys naming a
+// flag set that flag, unless it was given on the command line; all other
+// keys are kept as settings for the features that consult them. It's
+// called at startup, before anything reads the flags concurrently.
+func loadConfig() error {
+	update, err := readConfigUpdate(true)
+	if err != nil {
+		return err
+	}
+	return update.apply()
+}
+
+// readConfigUpdate reads and parses the configuration file. Unless force
+// is set, it returns nil if the file is unchanged. Forcing it resolves the
+// secrets the file references anew, which may have changed even if the
+// file didn't.
+func readConfigUpdate(force bool) (*configUpdate, error) {
+	if *configFile == "" {
+		return nil, nil
+	}
+	raw, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !force && bytes.Equal(raw, configRaw) {
+		return nil, nil
+	}
+	decrypted, err := decryptConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err := expandSecrets(values); err != nil {
+		return nil, err
+	}
+
+	if commandLineFlags == nil { // before any flag is set from the configuration
+		commandLineFlags = map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { commandLineFlags[f.Name] = true })
+	}
+	update := &configUpdate{raw: raw, flags: map[string]string{}, settings: map[string]string{}}
+	for key, value := range values {
+		switch {
+		case flag.Lookup(key) == nil:
+			update.settings[key] = value
+		case !commandLineFlags[key]:
+			update.flags[key] = value
+		}
+	}
+	return update, nil
+}
+
+// apply sets the flags of the configuration and replaces the settings.
+// Flags are read without a lock, so once the exporter is running, apply is
+// only called while neither a registration nor a scrape runs, holding
+// metricsRegisteredMu and scrapeMu. Flags read elsewhere only take effect
+// on restart.
+func (u *configUpdate) apply() error {
+	if u == nil {
+		return nil
+	}
+	for key, value := range u.flags {
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("Invalid value for %s in %s: %s", key, *configFile, err)
+		}
+	}
+
+	settingsMu.Lock()
+	settings = u.settings
+	settingsMu.Unlock()
+	configRaw = u.raw
+	log.Printf("Loaded configuration from %s", *configFile)
+	return nil
+}
+
+func readConfig() ([]byte, error) {
+	if !strings.HasPrefix(*configFile, "http://") && !strings.HasPrefix(*configFile, "https://") {
+		return ioutil.ReadFile(*configFile)
+	}
+
+	req, err := http.NewRequest("GET", *configFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	if *configBearerTokenFile != "" {
+		token, err := ioutil.ReadFile(*configBearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s returned %s", *configFile, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func parseConfig(raw []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key = value, got: %s", *configFile, n, line)
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values, scanner.Err()
+}
+
+// refreshConfig periodically refetches a configuration loaded from a URL
+// and applies it like a reload if it changed, registering the plugins of
+// a new munin-node address. Settings consulted at runtime take effect right
+// away, flags that are only read at startup need a restart.
+func refreshConfig() {
+	if !strings.Contains(*configFile, "://") || *configRefreshInterval <= 0 {
+		return
+	}
+	for range time.Tick(*configRefreshInterval) {
+		update, err := readConfigUpdate(false)
+		if err == nil && update != nil {
+			err = applyConfigUpdate(update)
+		}
+		if err != nil {
+			log.Printf("Couldn't refresh configuration: %s", err)
+		}
+	}
+}
+
+// setting returns the value of a configuration key that isn't a flag.
+func setting(key string) (string, bool) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	value, ok := settings[key]
+	return value, ok
+}
+
+// settingsWithPrefix returns the settings whose keys start with prefix,
+// keyed by the rest of the key.
+func settingsWithPrefix(prefix string) map[string]string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	matching := map[string]string{}
+	for key, value := range settings {
+		if strings.HasPrefix(key, prefix) {
+			matching[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+	return matching
+}
+
+// globLiterals returns the number of characters of pattern matching only
+// themselves.
+func globLiterals(pattern string) int {
+	n := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			if j := strings.IndexByte(pattern[i:], ']'); j > 0 {
+				i += j
+			}
+		case '\\':
+			i++
+			n++
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// moreSpecific reports whether glob a is more specific than glob b: it has
+// more literal characters, or as many and is shorter, or else comes first
+// alphabetically, so that of several matching globs, the same one wins
+// every time.
+func moreSpecific(a, b string) bool {
+	if la, lb := globLiterals(a), globLiterals(b); la != lb {
+		return la > lb
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// mostSpecificMatch returns the value of the most specific glob in
+// patterns, as ranked by moreSpecific, matching name.
+func mostSpecificMatch(patterns map[string]string, name string) (string, bool) {
+	best, value, found := "", "", false
+	for pattern, v := range patterns {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if !found || moreSpecific(pattern, best) {
+			best, value, found = pattern, v, true
+		}
+	}
+	return value, found
+}
+
+// pluginSetting returns the value of the "<prefix>.<plugin glob>.<field glob>"
+// key matching plugin and field. Of several matching keys, the one with the
+// most specific plugin glob wins, then the one with the most specific field
+// glob.
+func pluginSetting(prefix, plugin, field string) (string, bool) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	var bestPlugin, bestField, value string
+	found := false
+	for key, v := range settings {
+		if !strings.HasPrefix(key, prefix+".") {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix+".")
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			continue
+		}
+		pluginGlob, fieldGlob := key[:i], key[i+1:]
+		pluginOK, _ := path.Match(pluginGlob, plugin)
+		fieldOK, _ := path.Match(fieldGlob, field)
+		if !pluginOK || !fieldOK {
+			continue
+		}
+		better := !found || moreSpecific(pluginGlob, bestPlugin)
+		if found && pluginGlob == bestPlugin {
+			better = moreSpecific(fieldGlob, bestField)
+		}
+		if better {
+			bestPlugin, bestField, value, found = pluginGlob, fieldGlob, v, true
+		}
+	}
+	return value, found
+}
+
+// pluginLabels returns the constant labels configured for plugin with
+// "label.<plugin glob>.<label name> = <value>" keys, e.g.
+// "label.postgres_*.service = postgresql". Where several globs set the same
+// label, the most specific one wins.
+func pluginLabels(plugin string) map[string]string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	labels := map[string]string{}
+	globs := map[string]string{} // the glob each label was set for
+	for key, value := range settings {
+		if !strings.HasPrefix(key, "label.") {
+			continue
+		}
+		key = strings.TrimPrefix(key, "label.")
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			continue
+		}
+		glob, name := key[:i], key[i+1:]
+		if ok, _ := path.Match(glob, plugin); !ok {
+			continue
+		}
+		if best, set := globs[name]; !set || moreSpecific(glob, best) {
+			labels[name], globs[name] = value, glob
+		}
+	}
+	return labels
+}
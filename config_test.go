@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestPluginSetting(t *testing.T) {
+	settingsMu.Lock()
+	settings = map[string]string{
+		"smooth.*.*":             "1",
+		"smooth.if_*.*":          "2",
+		"smooth.if_eth0.*":       "3",
+		"smooth.if_eth0.down":    "4",
+		"smooth.if_eth?.up":      "5",
+		"smooth.sensors_*.temp*": "6",
+		"smooth.sensors_*.temp1": "7",
+		"other.if_eth0.down":     "8",
+	}
+	settingsMu.Unlock()
+	defer func() {
+		settingsMu.Lock()
+		settings = map[string]string{}
+		settingsMu.Unlock()
+	}()
+
+	for _, tt := range []struct {
+		plugin, field, want string
+	}{
+		{"load", "load", "1"},
+		{"if_eth1", "down", "2"},
+		{"if_eth0", "up", "3"}, // the plugin glob ranks first
+		{"if_eth0", "down", "4"},
+		{"if_eth1", "up", "5"},
+		{"sensors_cpu", "temp2", "6"},
+		{"sensors_cpu", "temp1", "7"},
+	} {
+		for i := 0; i < 10; i++ { // map order mustn't matter
+			got, ok := pluginSetting("smooth", tt.plugin, tt.field)
+			if !ok || got != tt.want {
+				t.Fatalf("pluginSetting(smooth, %s, %s) = %q, %v, want %q", tt.plugin, tt.field, got, ok, tt.want)
+			}
+		}
+	}
+	if got, ok := pluginSetting("ttl", "load", "load"); ok {
+		t.Errorf("pluginSetting(ttl, load, load) = %q, want none", got)
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	for _, tt := range []struct{ a, b string }{
+		{"if_eth0", "if_*"},
+		{"if_eth0", "if_eth?"},
+		{"if_eth?", "if_*"},
+		{"if_*", "*"},
+		{"a*", "b*"},
+	} {
+		if !moreSpecific(tt.a, tt.b) || moreSpecific(tt.b, tt.a) {
+			t.Errorf("%s isn't ranked more specific than %s", tt.a, tt.b)
+		}
+	}
+}
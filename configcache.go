@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var muninConfigCacheDir = flag.String("munin.config-cache-dir", "", "Directory to keep plugin configs in across restarts, so startup skips the config crawl. Disabled if empty.")
+
+const configCacheHeader = "# checksum "
+
+// configCacheChecksum identifies the plugin list and munin-node version the
+// cached configs belong to. Any change, e.g. one found by rediscovery,
+// invalidates all cached configs of the node.
+func configCacheChecksum() string {
+	_, version := nodeBanner()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", version, strings.Join(discovered, " "))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func configCachePath(plugin string) string {
+	banner, _ := nodeBanner()
+	return filepath.Join(*muninConfigCacheDir, url.PathEscape(banner), url.PathEscape(plugin))
+}
+
+// loadCachedConfigs stores the cached config of every plugin in names in
+// responses and returns the plugins without a valid cache entry.
+func loadCachedConfigs(names []string, responses map[string][]string) (missing []string) {
+	if *muninConfigCacheDir == "" {
+		return names
+	}
+	checksum := configCacheChecksum()
+	for _, name := range names {
+		lines, err := readCachedConfig(name, checksum)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Ignoring cached config of %s: %s", name, err)
+			}
+			missing = append(missing, name)
+			continue
+		}
+		responses[name] = lines
+	}
+	log.Printf("Loaded %d of %d plugin configs from cache", len(names)-len(missing), len(names))
+	return missing
+}
+
+func readCachedConfig(plugin, checksum string) ([]string, error) {
+	f, err := os.Open(configCachePath(plugin))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != configCacheHeader+checksum {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// storeCachedConfig caches the config response of plugin. Values sent
+// along by dirtyconfig nodes are left out, they'd be outdated when read.
+func storeCachedConfig(plugin string, lines []string) {
+	if *muninConfigCacheDir == "" {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(configCacheHeader + configCacheChecksum() + "\n")
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.HasSuffix(fields[0], ".value") {
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+
+	p := configCachePath(plugin)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		log.Printf("Couldn't cache config of %s: %s", plugin, err)
+		return
+	}
+	if err := ioutil.WriteFile(p, []byte(b.String()), 0644); err != nil {
+		log.Printf("Couldn't cache config of %s: %s", plugin, err)
+	}
+}
+
+// dropCachedConfigs removes the cached configs of plugins.
+func dropCachedConfigs(plugins []string) {
+	if *muninConfigCacheDir == "" {
+		return
+	}
+	for _, plugin := range plugins {
+		if err := os.Remove(configCachePath(plugin)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove cached config of %s: %s", plugin, err)
+		}
+	}
+}
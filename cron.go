@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a cron expression evaluated in a fixed time zone.
+type cronSchedule struct {
+	loc                           *time.Location
+	minute, hour, dom, month, dow uint64 // bit i set if value i matches
+	domRestricted, dowRestricted  bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses "[TZ=<zone>] <minute> <hour> <day of month> <month>
+// <day of week>" with the usual *, lists, ranges and steps, or one of
+// @hourly, @daily, @weekly and @monthly. Without TZ=, the local time zone
+// is used.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	s := &cronSchedule{loc: time.Local}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "TZ=") {
+		loc, err := time.LoadLocation(strings.TrimPrefix(fields[0], "TZ="))
+		if err != nil {
+			return nil, err
+		}
+		s.loc, fields = loc, fields[1:]
+	}
+	if len(fields) == 1 {
+		if expanded, ok := cronShortcuts[fields[0]]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields in schedule %q", spec)
+	}
+
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 { // both 0 and 7 are Sunday
+		s.dow |= 1
+	}
+	s.domRestricted, s.dowRestricted = fields[2] != "*", fields[4] != "*"
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of *, n, n-m, each
+// optionally followed by /step.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("Invalid step in %q", field)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("Invalid value in %q", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("Invalid range in %q", field)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", field, min, max)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether the wall clock time t matches the schedule.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// wallClock returns the wall clock time of t in loc as a time without
+// zone transitions, so minutes can be counted on it.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// firedBetween reports whether the schedule fired after since, up to and
+// including now. DST transitions are handled like this: a time skipped
+// when clocks are set forward fires at the first minute after the gap, and
+// a time repeated when clocks are set back fires only once.
+func (s *cronSchedule) firedBetween(since, now time.Time) bool {
+	t := since.Truncate(time.Minute).Add(time.Minute)
+	if since.IsZero() {
+		t = now.Truncate(time.Minute)
+	}
+	// the latest wall clock time reached so far; clocks are never set back
+	// by more than an hour or two
+	var latest time.Time
+	for u := t.Add(-3 * time.Hour); u.Before(t); u = u.Add(time.Minute) {
+		if wall := wallClock(u, s.loc); wall.After(latest) {
+			latest = wall
+		}
+	}
+	for ; !t.After(now); t = t.Add(time.Minute) {
+		wall := wallClock(t, s.loc)
+		// clocks set forward skip the wall clock times in between, and
+		// wall clock times up to latest were already passed before clocks
+		// were set back
+		for pending := latest.Add(time.Minute); !pending.After(wall); pending = pending.Add(time.Minute) {
+			if s.matches(pending) {
+				return true
+			}
+		}
+		if wall.After(latest) {
+			latest = wall
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strconv"
+	"time"
+)
+
+var muninDerive = flag.String("munin.derive", "counter", "How DERIVE fields are exported: counter for a counter that follows munin's value and counts on from 0 when the value drops, e.g. because the plugin's source was reset, rate for a gauge of the rate per second between two fetches.")
+
+// counterSample is the last value munin sent for a series of a COUNTER or
+// DERIVE field, and when.
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+var (
+	counterSamples = map[string]counterSample{} // by series
+
+	// counterMax holds the maximum rate per second of COUNTER fields,
+	// keyed by metric and field, +Inf if the field has none.
+	counterMax = map[string]float64{}
+
+	// rateMin holds the minimum of DERIVE fields exported as rates, keyed
+	// by metric and field, -Inf if the field has none.
+	rateMin = map[string]float64{}
+)
+
+// deriveAsRate reports whether fields of muninType are exported as gauges
+// of their rate.
+func deriveAsRate(muninType string) bool {
+	return muninType == "derive" && *muninDerive == "rate"
+}
+
+// exportedAsCounter reports whether fields of muninType are exported as
+// counters.
+func exportedAsCounter(muninType string) bool {
+	return (muninType == "counter" || muninType == "derive") && !deriveAsRate(muninType)
+}
+
+// registerRate records that field, of the metric name, is a DERIVE field
+// exported as a rate. Like munin, rates below the field's min are unknown.
+func registerRate(name, field string, config map[string]string) {
+	min := math.Inf(-1)
+	if v, err := strconv.ParseFloat(config["min"], 64); err == nil {
+		min = v
+	}
+	rateMin[name+"\xff"+field] = min
+}
+
+// registerCounter records that field, of the metric name, is a COUNTER
+// field, whose value wraps around.
+func registerCounter(name, field string, config map[string]string) {
+	max := math.Inf(1)
+	if v, err := strconv.ParseFloat(config["max"], 64); err == nil {
+		max = v
+	}
+	counterMax[name+"\xff"+field] = max
+}
+
+// counterIncrease returns by how much the counter of field in graph goes
+// up for munin's value at now, and the seconds since the last value, 0 if
+// there's none. The first value counts fully. A value lower than the last
+// one of a COUNTER field means it wrapped around, at 32 bits, or at 64
+// bits if the last value didn't fit into 32 bits, unless that would exceed
+// the field's max rate, like it does when the counter was reset. Then, and
+// for DERIVE fields, it counts from 0.
+func counterIncrease(name, graph, field string, value float64, now time.Time) (increase, seconds float64) {
+	key := name + "\xff" + graph + "\xff" + field
+	last, seen := counterSamples[key]
+	counterSamples[key] = counterSample{value: value, time: now}
+	if !seen {
+		return math.Max(value, 0), 0
+	}
+	seconds = now.Sub(last.time).Seconds()
+	if value >= last.value {
+		return value - last.value, seconds
+	}
+	if max, ok := counterMax[name+"\xff"+field]; ok && value >= 0 {
+		width := math.Exp2(32)
+		if last.value >= width {
+			width = math.Exp2(64)
+		}
+		increase = width - last.value + value
+		if increase <= max*seconds {
+			return increase, seconds
+		}
+	}
+	return math.Max(value, 0), seconds
+}
+
+// rate returns the rate per second of the DERIVE field in graph from its
+// last value to value at now, and false if there's no last value yet.
+// Rates below min are NaN.
+func rate(name, graph, field string, value, min float64, now time.Time) (float64, bool) {
+	key := name + "\xff" + graph + "\xff" + field
+	last, seen := counterSamples[key]
+	counterSamples[key] = counterSample{value: value, time: now}
+	if !seen || !now.After(last.time) {
+		return 0, false
+	}
+	perSecond := (value - last.value) / now.Sub(last.time).Seconds()
+	if perSecond < min {
+		return math.NaN(), true
+	}
+	return perSecond, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterIncrease(t *testing.T) {
+	defer func() { counterSamples, counterMax = map[string]counterSample{}, map[string]float64{} }()
+	registerCounter("if_rx", "rx", map[string]string{"max": "1000"})
+	now := time.Unix(1e9, 0)
+	for _, tt := range []struct {
+		value             float64
+		increase, seconds float64
+	}{
+		{100, 100, 0}, // the first value counts fully
+		{400, 300, 10},
+		{math.Exp2(32) - 100, math.Exp2(32) - 500, 10},
+		{50, 150, 10}, // wrapped around at 32 bits
+		{10, 10, 10},  // wrapping would exceed the max rate: reset
+		{10, 0, 10},
+	} {
+		increase, seconds := counterIncrease("if_rx", "if", "rx", tt.value, now)
+		if increase != tt.increase || seconds != tt.seconds {
+			t.Errorf("counterIncrease(%v) = %v, %v, want %v, %v", tt.value, increase, seconds, tt.increase, tt.seconds)
+		}
+		now = now.Add(10 * time.Second)
+	}
+}
+
+func TestRate(t *testing.T) {
+	defer func() { counterSamples = map[string]counterSample{} }()
+	start := time.Unix(1e9, 0)
+	if _, ok := rate("net", "net", "rx", 100, 0, start); ok {
+		t.Errorf("rate of the first value is known")
+	}
+	if got, ok := rate("net", "net", "rx", 300, 0, start.Add(10*time.Second)); !ok || got != 20 {
+		t.Errorf("rate = %v, %v, want 20", got, ok)
+	}
+	if got, ok := rate("net", "net", "rx", 200, 0, start.Add(20*time.Second)); !ok || !math.IsNaN(got) {
+		t.Errorf("rate below min = %v, %v, want NaN", got, ok)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+const unixPrefix = "unix://"
+
+var (
+	muninConnectTimeout = flag.Duration("munin.connect-timeout", 10*time.Second, "Timeout for establishing the connection to munin-node, 0 to wait forever.")
+	muninReadTimeout    = flag.Duration("munin.read-timeout", time.Minute, "Timeout for each line of a munin-node response, 0 to wait forever.")
+	muninWriteTimeout   = flag.Duration("munin.write-timeout", 10*time.Second, "Timeout for sending a command to munin-node, 0 to wait forever.")
+	muninKeepalive      = flag.Bool("munin.keepalive", true, "Enable TCP keepalive on the munin-node connection.")
+	muninKeepaliveIntvl = flag.Duration("munin.keepalive-interval", 30*time.Second, "Interval between TCP keepalive probes.")
+	muninSourceAddress  = flag.String("munin.source-address", "", "Local IP address to dial munin-node from.")
+
+	muninConnectionPerScrape = flag.Bool("munin.connection-per-scrape", false, "Open a new munin-node connection for every scrape and close it afterwards instead of keeping one open.")
+
+	muninIPv4Only   = flag.Bool("4", false, "Connect to munin-node over IPv4 only.")
+	muninIPv6Only   = flag.Bool("6", false, "Connect to munin-node over IPv6 only.")
+	muninPreferIPv6 = flag.Bool("munin.prefer-ipv6", false, "Try the IPv6 addresses of munin-node before its IPv4 addresses.")
+)
+
+// dialMunin opens a connection to munin-node. Addresses starting with
+// unix:// are dialed as Unix domain sockets, everything else as TCP,
+// possibly through an SSH jump host or a proxy.
+func dialMunin() (net.Conn, error) {
+	release := acquireDial()
+	defer release()
+	address, err := munin.NormalizeAddress(*muninAddress)
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := muninDialer(!strings.HasPrefix(address, unixPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if mockNode != nil {
+		return dialMock()
+	}
+	if *replayFile != "" {
+		return dialReplay()
+	}
+	if strings.HasPrefix(address, unixPrefix) {
+		return dialer.Dial("unix", strings.TrimPrefix(address, unixPrefix))
+	}
+	network, err := muninNetwork()
+	if err != nil {
+		return nil, err
+	}
+	if *muninSSHHost != "" {
+		return dialSSH(dialer, network, address)
+	}
+	proxyURL, err := muninProxy(address)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		return dialProxy(proxyURL, dialer, network, address)
+	}
+	if *muninPreferIPv6 && network == proto {
+		return dialPreferIPv6(dialer, address)
+	}
+	return dialer.Dial(network, address)
+}
+
+// muninDialer returns the dialer for connections to munin-node or a jump
+// host on the way, with the connect timeout and keepalive configured, and
+// for TCP the source address.
+func muninDialer(tcp bool) (*net.Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout:   *muninConnectTimeout,
+		KeepAlive: *muninKeepaliveIntvl,
+	}
+	if !*muninKeepalive {
+		dialer.KeepAlive = -1 // negative disables keepalive
+	}
+	if *muninSourceAddress != "" && tcp {
+		ip, zone := strings.Trim(*muninSourceAddress, "[]"), ""
+		if i := strings.Index(ip, "%"); i >= 0 {
+			ip, zone = ip[:i], ip[i+1:]
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("Invalid source address %q", *muninSourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: parsed, Zone: zone}
+	}
+	return dialer, nil
+}
+
+// muninNetwork returns the network to dial munin-node on as selected by
+// -4 and -6.
+func muninNetwork() (string, error) {
+	switch {
+	case *muninIPv4Only && *muninIPv6Only:
+		return "", fmt.Errorf("-4 and -6 can't be used together")
+	case *muninIPv4Only:
+		return proto + "4", nil
+	case *muninIPv6Only:
+		return proto + "6", nil
+	}
+	return proto, nil
+}
+
+// dialPreferIPv6 dials the addresses address resolves to one after the
+// other, IPv6 addresses first.
+func dialPreferIPv6(dialer *net.Dialer, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return addrs[i].IP.To4() == nil && addrs[j].IP.To4() != nil
+	})
+	err = fmt.Errorf("No addresses found for %s", host)
+	for _, addr := range addrs {
+		var conn net.Conn
+		ip := addr.IP.String()
+		if addr.Zone != "" {
+			ip += "%" + addr.Zone
+		}
+		conn, err = dialer.Dial(proto, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		log.Printf("Couldn't connect to %s: %s", ip, err)
+	}
+	return nil, err
+}
+
+// muninTarget returns -muninAddress normalized by munin.NormalizeAddress,
+// or as given if it is invalid; dialing reports the error then.
+func muninTarget() string {
+	address, err := munin.NormalizeAddress(*muninAddress)
+	if err != nil {
+		return *muninAddress
+	}
+	return address
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"sort"
+)
+
+var muninDirtyconfig = flag.Bool("munin.dirtyconfig", true, "Use the values nodes with the dirtyconfig capability send along with plugin configs. They only stand in for the first fetch after a plugin is registered; later scrapes fetch the plugin as usual.")
+
+// dirtyFetched holds the plugins whose values arrived with their config,
+// so the next scrape doesn't need to fetch them again.
+var dirtyFetched = map[string]bool{}
+
+// exportDirtyConfig exports the values included in the config of plugin by
+// nodes supporting dirtyconfig, the same way fetched values are exported.
+// It returns true if every field had a value.
+func exportDirtyConfig(plugin string, configs []graphConfig) bool {
+	if !*muninDirtyconfig || !hasCap("dirtyconfig") || spoolEnabled() {
+		return false
+	}
+	var lines []string
+	graph := plugin
+	for _, c := range configs {
+		if len(c.config.Fields) == 0 {
+			continue
+		}
+		if c.graph != graph {
+			graph = c.graph
+			lines = append(lines, "multigraph "+graph)
+		}
+		fields := make([]string, 0, len(c.config.Fields))
+		for field, attributes := range c.config.Fields {
+			if _, ok := attributes["value"]; !ok {
+				return false // plugin doesn't support dirtyconfig
+			}
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			lines = append(lines, field+".value "+c.config.Fields[field]["value"])
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	return exportFetch(plugin, lines) == nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	discoveryWebhook         = flag.String("discovery.webhook", "", "URL to POST a JSON summary to whenever rediscovery adds or removes plugins.")
+	muninRediscoveryInterval = flag.Duration("munin.rediscovery-interval", 0, "Interval at which to re-read the plugin list and register plugins enabled since, e.g. 1h. 0 only rediscovers when munin-node seems to have been restarted.")
+
+	lastDiscovery time.Time
+
+	discoveryChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "munin_discovery_plugin_changes_total",
+			Help: "Number of plugins added or removed by rediscovery.",
+		},
+		[]string{"hostname", "change"},
+	)
+)
+
+func init() {
+	registry.MustRegister(discoveryChanges)
+}
+
+// discoveryDelta summarizes how the plugin list changed between two
+// discoveries of the same node.
+type discoveryDelta struct {
+	Hostname string    `json:"hostname"`
+	Address  string    `json:"address"`
+	Time     time.Time `json:"time"`
+	Added    []string  `json:"added"`
+	Removed  []string  `json:"removed"`
+}
+
+// rediscoveryDue reports whether -munin.rediscovery-interval passed since
+// the plugin list was last read.
+func rediscoveryDue(now time.Time) bool {
+	if *muninRediscoveryInterval <= 0 {
+		return false
+	}
+	if lastDiscovery.IsZero() { // read at startup
+		lastDiscovery = now
+	}
+	return now.Sub(lastDiscovery) >= *muninRediscoveryInterval
+}
+
+// rediscover re-reads the plugin list, registers plugins that appeared
+// since the last discovery and stops fetching plugins that disappeared.
+func rediscover() (err error) {
+	lastDiscovery = time.Now()
+	items, err := muninList()
+	if err != nil {
+		return
+	}
+
+	nodeList, err := muninNodes()
+	if err != nil {
+		return
+	}
+	nodesMu.Lock()
+	nodes = nodeList
+	nodesMu.Unlock()
+
+	added, removed := diffPlugins(discovered, items)
+	if len(added) == 0 && len(removed) == 0 {
+		discovered = items
+		return nil
+	}
+	dropCachedConfigs(removed)
+
+	if len(removed) > 0 {
+		graphs = withoutPlugins(graphs, removed)
+		for _, name := range removed {
+			unregisterPlugin(name)
+		}
+	}
+	if err := registerGraphs(added); err != nil {
+		// the added plugins are new again to the next attempt
+		discovered = withoutPlugins(items, added)
+		if len(removed) > 0 {
+			notifyDiscoveryChange(nil, removed)
+			updateNodeTags()
+		}
+		return err
+	}
+	discovered = items
+	notifyDiscoveryChange(added, removed)
+	updateNodeTags()
+	return nil
+}
+
+// withoutPlugins returns the plugins of list that aren't in drop.
+func withoutPlugins(list, drop []string) []string {
+	gone := map[string]bool{}
+	for _, name := range drop {
+		gone[name] = true
+	}
+	var kept []string
+	for _, name := range list {
+		if !gone[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// diffPlugins returns the plugins only present in current (added) and the
+// ones only present in previous (removed).
+func diffPlugins(previous, current []string) (added, removed []string) {
+	seen := map[string]bool{}
+	for _, name := range previous {
+		seen[name] = true
+	}
+	for _, name := range current {
+		if !seen[name] {
+			added = append(added, name)
+		}
+		delete(seen, name)
+	}
+	for _, name := range previous {
+		if seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	return
+}
+
+func notifyDiscoveryChange(added, removed []string) {
+	log.Printf("WARN: plugins on %s changed, added: [%s], removed: [%s]",
+		nodeHostname(), strings.Join(added, " "), strings.Join(removed, " "))
+	discoveryChanges.WithLabelValues(nodeHostname(), "added").Add(float64(len(added)))
+	discoveryChanges.WithLabelValues(nodeHostname(), "removed").Add(float64(len(removed)))
+
+	if *discoveryWebhook == "" {
+		return
+	}
+	banner, _ := nodeBanner()
+	body, err := json.Marshal(discoveryDelta{
+		Hostname: banner,
+		Address:  muninTarget(),
+		Time:     time.Now(),
+		Added:    added,
+		Removed:  removed,
+	})
+	if err != nil {
+		log.Printf("Couldn't encode discovery notification: %s", err)
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(*discoveryWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Couldn't send discovery notification: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("Discovery webhook returned %s", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// expectedFields holds the fields announced by the config of each
+	// graph, by plugin and graph.
+	expectedFields = map[string]map[string]map[string]bool{}
+
+	fieldsMissing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_plugin_fields_missing",
+			Help: "Number of fields announced by the config of a graph that were missing from its last fetch.",
+		},
+		[]string{"hostname", "plugin", "graphname"},
+	)
+	fieldsUnexpected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_plugin_fields_unexpected",
+			Help: "Number of fields in the last fetch of a graph that its config didn't announce.",
+		},
+		[]string{"hostname", "plugin", "graphname"},
+	)
+)
+
+func init() {
+	registry.MustRegister(fieldsMissing, fieldsUnexpected)
+}
+
+// expectFields records the fields announced by the config of graph.
+func expectFields(plugin, graph string, fields map[string]map[string]string) {
+	if expectedFields[plugin] == nil {
+		expectedFields[plugin] = map[string]map[string]bool{}
+	}
+	expected := map[string]bool{}
+	for field := range fields {
+		expected[field] = true
+	}
+	expectedFields[plugin][graph] = expected
+}
+
+// checkDrift compares the fields fetched from plugin, by graph, to those
+// announced by its config. Plugins that lost access to some of their
+// devices typically still answer, just with fewer fields.
+func checkDrift(plugin string, fetched map[string]map[string]bool) {
+	expected := expectedFields[plugin]
+	graphs := map[string]bool{}
+	for graph := range expected {
+		graphs[graph] = true
+	}
+	for graph := range fetched {
+		graphs[graph] = true
+	}
+	for graph := range graphs {
+		missing, unexpected := 0, 0
+		for field := range expected[graph] {
+			if !fetched[graph][field] {
+				missing++
+			}
+		}
+		for field := range fetched[graph] {
+			if !expected[graph][field] {
+				unexpected++
+			}
+		}
+		fieldsMissing.WithLabelValues(nodeHostname(), plugin, graph).Set(float64(missing))
+		fieldsUnexpected.WithLabelValues(nodeHostname(), plugin, graph).Set(float64(unexpected))
+	}
+}
@@ -0,0 +1,58 @@
+// Package exporter holds the extension points of munin_exporter. Custom
+// builds register their sample hooks from an init function of their own
+// package, which the main package imports for its side effects:
+//
+//	import _ "example.com/myhooks"
+package exporter
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is a single value on its way from munin to the exposition.
+type Sample struct {
+	Name   string // metric name
+	Plugin string
+	Graph  string
+	Field  string
+	Value  float64
+	// Timestamp is the time munin sampled the value at, if it told. Such
+	// samples are exposed with their timestamp.
+	Timestamp time.Time
+}
+
+// SampleHook transforms a sample into any number of samples: none to drop
+// it, several to derive additional series.
+type SampleHook func(Sample) []Sample
+
+var (
+	sampleHooks   []SampleHook
+	sampleHooksMu sync.RWMutex
+)
+
+// RegisterSampleHook adds a hook applied to every fetched sample before it
+// is exported. Hooks run in the order they were registered, each on the
+// output of the previous one.
+func RegisterSampleHook(hook SampleHook) {
+	sampleHooksMu.Lock()
+	defer sampleHooksMu.Unlock()
+	sampleHooks = append(sampleHooks, hook)
+}
+
+// ApplySampleHooks runs the registered hooks on sample and returns the
+// samples to export in its place.
+func ApplySampleHooks(sample Sample) []Sample {
+	sampleHooksMu.RLock()
+	defer sampleHooksMu.RUnlock()
+
+	samples := []Sample{sample}
+	for _, hook := range sampleHooks {
+		var next []Sample
+		for _, s := range samples {
+			next = append(next, hook(s)...)
+		}
+		samples = next
+	}
+	return samples
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pluginFamily groups the instances of a munin wildcard plugin, like all
+// if_<interface> plugins, so they can be configured at once.
+type pluginFamily struct {
+	name    string
+	pattern string   // glob, each * matching one part of the plugin name
+	labels  []string // label names for the parts matched by the *s
+	enabled bool
+}
+
+// builtinFamilies are the wildcard plugins shipped with munin. More
+// specific patterns come first.
+var builtinFamilies = []pluginFamily{
+	{name: "if_err", pattern: "if_err_*", labels: []string{"interface"}, enabled: true},
+	{name: "if", pattern: "if_*", labels: []string{"interface"}, enabled: true},
+	{name: "ip", pattern: "ip_*", labels: []string{"address"}, enabled: true},
+	{name: "smart", pattern: "smart_*", labels: []string{"device"}, enabled: true},
+	{name: "diskstat", pattern: "diskstat_*", labels: []string{"device"}, enabled: true},
+	{name: "postgres", pattern: "postgres_*_*", labels: []string{"check", "database"}, enabled: true},
+	{name: "mysql", pattern: "mysql_*", labels: []string{"check"}, enabled: true},
+	{name: "apache", pattern: "apache_*", labels: []string{"check"}, enabled: true},
+	{name: "nginx", pattern: "nginx_*", labels: []string{"check"}, enabled: true},
+}
+
+// families returns the configured families followed by the builtin ones.
+// Families are configured with keys like
+//
+//	family.<name>.pattern = if_*
+//	family.<name>.labels = interface
+//	family.<name>.enabled = false
+//
+// where a configured name equal to a builtin one overrides it.
+func families() []pluginFamily {
+	settingsMu.RLock()
+	configured := map[string]*pluginFamily{}
+	for key, value := range settings {
+		parts := strings.Split(key, ".")
+		if len(parts) != 3 || parts[0] != "family" {
+			continue
+		}
+		f, ok := configured[parts[1]]
+		if !ok {
+			f = &pluginFamily{name: parts[1], enabled: true}
+			for _, builtin := range builtinFamilies {
+				if builtin.name == f.name {
+					*f = builtin
+				}
+			}
+			configured[parts[1]] = f
+		}
+		switch parts[2] {
+		case "pattern":
+			f.pattern = value
+		case "labels":
+			f.labels = strings.Split(value, ",")
+		case "enabled":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Printf("Ignoring invalid value %q for %s", value, key)
+				continue
+			}
+			f.enabled = enabled
+		}
+	}
+	settingsMu.RUnlock()
+
+	var names []string
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []pluginFamily
+	for _, name := range names {
+		if configured[name].pattern != "" {
+			result = append(result, *configured[name])
+		}
+	}
+	for _, builtin := range builtinFamilies {
+		if _, ok := configured[builtin.name]; !ok {
+			result = append(result, builtin)
+		}
+	}
+	return result
+}
+
+// pluginFamilyOf returns the family of plugin, if any, and the labels
+// extracted from its name.
+func pluginFamilyOf(plugin string) (*pluginFamily, map[string]string) {
+	for _, f := range families() {
+		parts := matchFamily(f.pattern, plugin)
+		if parts == nil {
+			continue
+		}
+		labels := map[string]string{}
+		for i, name := range f.labels {
+			name = strings.TrimSpace(name)
+			if i < len(parts) && name != "" {
+				labels[name] = parts[i]
+			}
+		}
+		return &f, labels
+	}
+	return nil, nil
+}
+
+// matchFamily matches plugin against a family glob and returns the parts
+// matched by each *, or nil if it doesn't match. All but the last * match
+// as little as possible, so postgres_*_* splits postgres_size_my_db into
+// "size" and "my_db".
+func matchFamily(pattern, plugin string) []string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	stars := strings.Count(pattern, "*")
+	for i, part := range strings.Split(pattern, "*") {
+		expr.WriteString(regexp.QuoteMeta(part))
+		switch {
+		case i == stars:
+		case i == stars-1:
+			expr.WriteString("(.+)")
+		default:
+			expr.WriteString("(.+?)")
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil
+	}
+	matches := re.FindStringSubmatch(plugin)
+	if matches == nil {
+		return nil
+	}
+	return matches[1:]
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+var fatalErrorPolicy = flag.String("fatal-error-policy", "exit", "What to do when loading the configuration or serving HTTP fails, or connecting or registering metrics for -once and the subcommands: exit with a status telling the step apart, degrade to keep running without it, or retry until it succeeds.")
+
+// Exit statuses of the steps run under the fatal error policy.
+const (
+	exitConfig   = 2
+	exitConnect  = 3
+	exitRegister = 4
+	exitServe    = 5
+)
+
+// runStep runs step under -fatal-error-policy. It returns false if step
+// failed and the exporter continues without it.
+func runStep(what string, status int, step func() error) bool {
+	for {
+		err := step()
+		if err == nil {
+			return true
+		}
+		switch *fatalErrorPolicy {
+		case "retry":
+			log.Printf("%s failed, retrying: %s", what, err)
+			time.Sleep(retryInterval * time.Second)
+		case "degrade":
+			log.Printf("%s failed, continuing without: %s", what, err)
+			return false
+		default:
+			log.Printf("%s failed: %s", what, err)
+			os.Exit(status)
+		}
+	}
+}
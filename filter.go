@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+)
+
+var (
+	pluginInclude = flag.String("plugin.include", "", "Regular expression plugins have to match entirely to be scraped. All plugins if empty.")
+	pluginExclude = flag.String("plugin.exclude", "", "Regular expression of plugins not to scrape, matching the entire plugin name, e.g. exim_.*. None if empty.")
+)
+
+// filterPlugins returns the plugins of a list response that match
+// -plugin.include and don't match -plugin.exclude.
+func filterPlugins(plugins []string) ([]string, error) {
+	if *pluginInclude == "" && *pluginExclude == "" {
+		return plugins, nil
+	}
+	include, err := anchoredRegexp(*pluginInclude)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -plugin.include: %s", err)
+	}
+	exclude, err := anchoredRegexp(*pluginExclude)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -plugin.exclude: %s", err)
+	}
+	var kept []string
+	for _, plugin := range plugins {
+		if include != nil && !include.MatchString(plugin) || exclude != nil && exclude.MatchString(plugin) {
+			log.Printf("Skipping %s, filtered by -plugin.include/-plugin.exclude", plugin)
+			continue
+		}
+		kept = append(kept, plugin)
+	}
+	return kept, nil
+}
+
+// anchoredRegexp compiles expr to match entire strings, or returns nil if
+// expr is empty.
+func anchoredRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
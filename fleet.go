@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var (
+	muninLazyConnect = flag.Bool("munin.lazy-connect", false, "Don't connect to munin-node at startup, but on the first scrape, and keep retrying failed registrations instead of applying -fatal-error-policy.")
+	muninMaxDials    = flag.Int("munin.max-concurrent-dials", 0, "Maximum number of connections to munin-node being established at the same time. 0 means no limit.")
+
+	metricsRegistered   bool
+	metricsRegisteredMu sync.Mutex
+	registeredCh        = make(chan struct{}) // closed once the metrics are registered
+
+	dialSlots     chan struct{}
+	dialSlotsOnce sync.Once
+)
+
+// ensureRegistered registers the metrics, unless that succeeded before.
+func ensureRegistered() error {
+	metricsRegisteredMu.Lock()
+	defer metricsRegisteredMu.Unlock()
+	if metricsRegistered {
+		return nil
+	}
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+	metricsRegistered = true
+	close(registeredCh)
+	return nil
+}
+
+// acquireDial waits for a free slot under -munin.max-concurrent-dials and
+// returns the function releasing it.
+func acquireDial() func() {
+	if *muninMaxDials <= 0 {
+		return func() {}
+	}
+	dialSlotsOnce.Do(func() { dialSlots = make(chan struct{}, *muninMaxDials) })
+	dialSlots <- struct{}{}
+	return func() { <-dialSlots }
+}
+
+// page returns the range of n items requested with the offset and limit
+// parameters of r, all of them by default. The total is passed along in the
+// X-Total-Count header of w.
+func page(w http.ResponseWriter, r *http.Request, n int) (from, to int, err error) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(n))
+	from, to = 0, n
+	if offset := r.URL.Query().Get("offset"); offset != "" {
+		if from, err = strconv.Atoi(offset); err != nil || from < 0 {
+			return 0, 0, fmt.Errorf("Invalid offset %q", offset)
+		}
+		if from > n {
+			from = n
+		}
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 0 {
+			return 0, 0, fmt.Errorf("Invalid limit %q", limit)
+		}
+		if from+l < to {
+			to = from + l
+		}
+	}
+	return from, to, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var muninBaseLabel = flag.Bool("munin.base-label", false, "Attach a base label to metrics, the base of the unit prefixes munin displays their graph's values with: 1024 for graph_args --base 1024, 1 for graph_scale no, 1000 otherwise.")
+
+// graphBase returns the base of the unit prefixes munin displays values of
+// the graph configured by graphConfig with, "1" if it displays them
+// unscaled.
+func graphBase(graphConfig map[string]string) string {
+	if strings.EqualFold(graphConfig["graph_scale"], "no") {
+		return "1"
+	}
+	args := strings.Fields(graphConfig["graph_args"])
+	for i, arg := range args {
+		switch {
+		case (arg == "--base" || arg == "-b") && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--base="):
+			return strings.TrimPrefix(arg, "--base=")
+		}
+	}
+	return "1000"
+}
+
+// withBaseLabel returns labels along with the base label of the graph
+// configured by graphConfig, if enabled.
+func withBaseLabel(labels map[string]string, graphConfig map[string]string) map[string]string {
+	if !*muninBaseLabel {
+		return labels
+	}
+	withBase := map[string]string{"base": graphBase(graphConfig)}
+	for k, v := range labels {
+		withBase[k] = v
+	}
+	return withBase
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// lastScrapeOK is 1 if the last scrape of all plugins succeeded.
+var lastScrapeOK int32
+
+// serveHealthy tells the process is alive.
+func serveHealthy(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy")
+}
+
+// serveReady tells whether the exporter is connected to munin-node and
+// has scraped all plugins successfully, the last time as well as at least
+// once before.
+func serveReady(w http.ResponseWriter, r *http.Request) {
+	if ready, reason := readiness(); !ready {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Ready")
+}
+
+func readiness() (bool, string) {
+	metricsRegisteredMu.Lock()
+	registered := metricsRegistered
+	metricsRegisteredMu.Unlock()
+	switch {
+	case !registered:
+		return false, "Metrics not registered yet"
+	case atomic.LoadInt32(&warmedUp) == 0:
+		return false, "No successful scrape yet"
+	case atomic.LoadInt32(&lastScrapeOK) == 0:
+		return false, "Last scrape failed"
+	}
+	return true, ""
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pvdh/munin_exporter/exporter"
+)
+
+// Sample is a single value on its way from munin to the exposition. Sample
+// hooks are registered with exporter.RegisterSampleHook.
+type Sample = exporter.Sample
+
+func applySampleHooks(sample Sample) []Sample {
+	return exporter.ApplySampleHooks(sample)
+}
+
+// registerHookGauge registers a gauge for a metric name introduced by a
+// sample hook, cataloged under the sample's plugin and field like the
+// metrics of munin's fields. It returns false if the metric couldn't be
+// registered.
+func registerHookGauge(s Sample) bool {
+	if s.Name == metricNameFor(s.Graph, s.Field) {
+		return false // not renamed by a hook, but a field we don't export
+	}
+	help := "Derived from munin plugin " + s.Plugin + " by a sample hook."
+	constLabels := prometheus.Labels{"type": "gauge"}
+	gv := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        s.Name,
+			Help:        help,
+			ConstLabels: constLabels,
+		},
+		[]string{"hostname", "graphname", "muninlabel"},
+	)
+	if err := registry.Register(gv); err != nil {
+		log.Printf("Couldn't register %s from sample hook: %s", s.Name, err)
+		return false
+	}
+	log.Printf("Registered gauge %s from sample hook", s.Name)
+	gaugePerMetric[s.Name] = gv
+	addCatalogEntry(s.Name, "gauge", help, constLabels, s.Plugin, s.Field, "")
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+var hostnameLabel = flag.String("munin.hostname-label", "banner", "Where the hostname label comes from: banner (the name munin-node announces), target (the host of -muninAddress), reverse-dns (the name the address of munin-node resolves back to), host_name (the host_name set by plugins, falling back to the banner) or none to leave it empty.")
+
+var (
+	// nodeMu guards what every new connection learns about munin-node:
+	// bannerHostname, hostname, nodeVersion and peerAddress. The pool
+	// connects from concurrent registrations and fetches.
+	nodeMu sync.RWMutex
+	// bannerHostname is the hostname munin-node announced in its banner,
+	// whatever the hostname label is.
+	bannerHostname string
+	// peerAddress is the remote address of the latest munin connection.
+	peerAddress net.Addr
+	// graphHostNames holds the host_name set in the config of graphs.
+	graphHostNames = map[string]string{}
+)
+
+// nodeHostname returns the hostname label of munin-node.
+func nodeHostname() string {
+	nodeMu.RLock()
+	defer nodeMu.RUnlock()
+	return hostname
+}
+
+// nodeBanner returns the hostname munin-node announced in its banner and
+// its version.
+func nodeBanner() (hostname, version string) {
+	nodeMu.RLock()
+	defer nodeMu.RUnlock()
+	return bannerHostname, nodeVersion
+}
+
+// labelHostname returns the value of the hostname label of the node that
+// announced banner, according to -munin.hostname-label. The empty value of
+// none drops the label from the exposition.
+func labelHostname(banner string) string {
+	switch *hostnameLabel {
+	case "target":
+		target := muninTarget()
+		if host, _, err := net.SplitHostPort(target); err == nil {
+			return strings.SplitN(host, "%", 2)[0]
+		}
+		return banner // unix sockets have no host
+	case "reverse-dns":
+		if name := reverseDNS(); name != "" {
+			return name
+		}
+		return banner
+	case "none":
+		return ""
+	}
+	return banner
+}
+
+// reverseDNS returns the name the address of munin-node resolves to, or ""
+// if it doesn't.
+func reverseDNS() string {
+	nodeMu.RLock()
+	tcpAddr, ok := peerAddress.(*net.TCPAddr)
+	nodeMu.RUnlock()
+	if !ok {
+		return ""
+	}
+	names, err := net.LookupAddr(tcpAddr.IP.String())
+	if err != nil || len(names) == 0 {
+		log.Printf("Couldn't resolve %s, using the banner hostname: %v", tcpAddr.IP, err)
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// seriesIdentities returns the hostname labels the series of graph are
+// exported under. With -munin.hostname-label host_name, the host_name set
+// by the plugin takes the place of the node's hostname.
+func seriesIdentities(graph string) []string {
+	names := identities()
+	if *hostnameLabel != "host_name" || graphHostNames[graph] == "" {
+		return names
+	}
+	hostName := graphHostNames[graph]
+	identified := []string{hostName}
+	for _, name := range names[1:] {
+		if name != hostName {
+			identified = append(identified, name)
+		}
+	}
+	return identified
+}
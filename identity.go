@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+)
+
+var muninIdentities = flag.String("munin.identities", "", "Comma separated additional hostnames to export the node's metrics under, e.g. all names of a VIP. The node is still scraped only once.")
+
+var loggedDuplicates = map[string]bool{}
+
+// identities returns the hostname labels the node's values are exported
+// under: the hostname from the banner followed by the configured
+// identities. Identities resolving to the same node as the banner, i.e.
+// repeating its hostname, are dropped so nothing is exported twice.
+func identities() []string {
+	hostname := nodeHostname()
+	names := []string{hostname}
+	seen := map[string]bool{hostname: true}
+	for _, name := range strings.Split(*muninIdentities, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			if !loggedDuplicates[name] {
+				log.Printf("Identity %s duplicates the node's hostname, exporting it once", name)
+				loggedDuplicates[name] = true
+			}
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pluginFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "munin_plugin_failures_total",
+		Help: "Number of fetches of a plugin that failed, while the other plugins were still fetched.",
+	},
+	[]string{"hostname", "plugin"},
+)
+
+func init() {
+	registry.MustRegister(pluginFailures)
+}
+
+// unreachableError wraps errors meaning munin-node can't be reached at all,
+// as opposed to a single command failing.
+type unreachableError struct {
+	error
+}
+
+func (e unreachableError) Unwrap() error {
+	return e.error
+}
+
+// nodeUnreachable reports whether err means munin-node can't be reached,
+// so fetching further plugins is pointless.
+func nodeUnreachable(err error) bool {
+	var unreachable unreachableError
+	return errors.As(err, &unreachable)
+}
+
+// pluginFailed records that fetching plugin failed with err.
+func pluginFailed(plugin string, err error) {
+	log.Printf("Fetching %s failed, continuing with the other plugins: %s", plugin, err)
+	pluginStatus[plugin] = err.Error()
+	pluginFailures.WithLabelValues(nodeHostname(), plugin).Inc()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+)
+
+var muninScrapeJitter = flag.Duration("munin.scrape-jitter", 0, "Delay the first scrape and every further scrape in the background by a random duration up to this, so exporters started at the same time don't scrape their munin-nodes at the same time. 0 disables it.")
+
+// jitterRand is only used by the scrape loop.
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// scrapeJitter returns a random delay up to -munin.scrape-jitter.
+func scrapeJitter() time.Duration {
+	if *muninScrapeJitter <= 0 {
+		return 0
+	}
+	return time.Duration(jitterRand.Int63n(int64(*muninScrapeJitter)))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	journalFile     = flag.String("journal.file", "", "File to append a JSON record of every scrape cycle to. Disabled if empty.")
+	journalMaxSize  = flag.Int64("journal.max-size", 10*1024*1024, "Size in bytes after which the journal file is rotated.")
+	journalMaxFiles = flag.Int("journal.max-files", 5, "Number of rotated journal files to keep.")
+)
+
+// journalRecord is one line of the scrape journal.
+type journalRecord struct {
+	Time     time.Time         `json:"time"`
+	Duration float64           `json:"duration_seconds"`
+	Hostname string            `json:"hostname"`
+	Plugins  map[string]string `json:"plugins"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// writeJournal appends the outcome of a scrape cycle to the journal file.
+// Plugins not reached because the cycle was aborted are recorded as
+// "skipped".
+func writeJournal(start time.Time, duration time.Duration, cycleErr error) {
+	if *journalFile == "" {
+		return
+	}
+
+	banner, _ := nodeBanner()
+	record := journalRecord{
+		Time:     start,
+		Duration: duration.Seconds(),
+		Hostname: banner,
+		Plugins:  map[string]string{},
+	}
+	for _, graph := range graphs {
+		status, ok := pluginStatus[graph]
+		if !ok {
+			status = "skipped"
+		}
+		record.Plugins[graph] = status
+	}
+	if cycleErr != nil {
+		record.Error = cycleErr.Error()
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Couldn't encode journal record: %s", err)
+		return
+	}
+	if err := rotateJournal(); err != nil {
+		log.Printf("Couldn't rotate journal: %s", err)
+	}
+	f, err := os.OpenFile(*journalFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Couldn't open journal: %s", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Couldn't write journal: %s", err)
+	}
+}
+
+// rotateJournal shifts journal -> journal.1 -> journal.2 ... once the
+// journal exceeds its maximum size, dropping the oldest file.
+func rotateJournal() error {
+	info, err := os.Stat(*journalFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < *journalMaxSize {
+		return nil
+	}
+
+	if *journalMaxFiles < 1 {
+		return os.Remove(*journalFile)
+	}
+	for i := *journalMaxFiles - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", *journalFile, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", *journalFile, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(*journalFile, *journalFile+".1")
+}
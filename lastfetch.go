@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How the lines of a fetch response were handled, as shown by
+// /debug/lastfetch.
+const (
+	lineParsed           = "parsed"
+	lineSkippedMalformed = "skipped-malformed"
+	lineSkippedUnknown   = "skipped-unknown"
+	lineSkippedStrict    = "skipped-strict" // after a malformed line in -strict mode
+	lineExtinfo          = "extinfo"
+	lineMultigraph       = "multigraph"
+	lineComment          = "comment"
+	lineEndMarker        = "end-marker"
+)
+
+// annotatedLine is a line of a fetch response along with how it was handled.
+type annotatedLine struct {
+	line     string
+	handling string
+}
+
+// fetchRecord is the most recent fetch response of a plugin.
+type fetchRecord struct {
+	time  time.Time
+	lines []annotatedLine
+}
+
+var (
+	lastFetches   = map[string]*fetchRecord{}
+	lastFetchesMu sync.RWMutex
+)
+
+// note appends line to the record with how it was handled.
+func (r *fetchRecord) note(line, handling string) {
+	r.lines = append(r.lines, annotatedLine{line: line, handling: handling})
+}
+
+// keepLastFetch replaces the last fetch record of plugin.
+func keepLastFetch(plugin string, r *fetchRecord) {
+	lastFetchesMu.Lock()
+	lastFetches[plugin] = r
+	lastFetchesMu.Unlock()
+}
+
+// serveLastFetch shows the lines of the most recent fetch of the plugin
+// given by the plugin parameter, each prefixed with how it was handled.
+// Without the parameter, it lists the plugins that were fetched.
+func serveLastFetch(w http.ResponseWriter, r *http.Request) {
+	plugin := r.URL.Query().Get("plugin")
+	lastFetchesMu.RLock()
+	defer lastFetchesMu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if plugin == "" {
+		var plugins []string
+		for name := range lastFetches {
+			plugins = append(plugins, name)
+		}
+		sort.Strings(plugins)
+		for _, name := range plugins {
+			fmt.Fprintf(w, "%s\n", name)
+		}
+		return
+	}
+	record, ok := lastFetches[plugin]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s hasn't been fetched", plugin), http.StatusNotFound)
+		return
+	}
+	if err := writeFetchRecord(w, plugin, record); err != nil {
+		log.Printf("Couldn't write last fetch response: %s", err)
+	}
+}
+
+// writeFetchRecord writes the lines of record, each prefixed with how it
+// was handled.
+func writeFetchRecord(w io.Writer, plugin string, record *fetchRecord) error {
+	fmt.Fprintf(w, "# fetch %s at %s\n", plugin, record.time.Format(time.RFC3339))
+	for _, l := range record.lines {
+		if _, err := fmt.Fprintf(w, "%-17s %s\n", l.handling, l.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
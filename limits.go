@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lastCycleNanos is the duration of the last scrape cycle.
+var lastCycleNanos int64
+
+func recordCycle(d time.Duration) {
+	atomic.StoreInt64(&lastCycleNanos, int64(d))
+}
+
+// limitsCollector exposes the exporter's own limits next to their current
+// usage, so operators can tell whether the exporter or munin-node is the
+// bottleneck.
+type limitsCollector struct {
+	limit, usage, cache *prometheus.Desc
+}
+
+func init() {
+	registry.MustRegister(&limitsCollector{
+		limit: prometheus.NewDesc(
+			"munin_exporter_resource_limit",
+			"Configured limit of an exporter resource.",
+			[]string{"resource"}, nil,
+		),
+		usage: prometheus.NewDesc(
+			"munin_exporter_resource_usage",
+			"Current usage of an exporter resource, comparable to munin_exporter_resource_limit.",
+			[]string{"resource"}, nil,
+		),
+		cache: prometheus.NewDesc(
+			"munin_exporter_cache_entries",
+			"Number of entries held in an exporter cache.",
+			[]string{"cache"}, nil,
+		),
+	})
+}
+
+func (lc *limitsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lc.limit
+	ch <- lc.usage
+	ch <- lc.cache
+}
+
+func (lc *limitsCollector) Collect(ch chan<- prometheus.Metric) {
+	inUse, waiting := muninPool.usage()
+	spoolSeries, spoolQueue := spool.usage()
+	cycle := time.Duration(atomic.LoadInt64(&lastCycleNanos))
+
+	// resource: limit, usage
+	resources := map[string][2]float64{
+		"connections":          {float64(*muninPoolMaxSize), float64(inUse)},
+		"spool_queue":          {float64(*muninSpoolQueue), float64(spoolQueue)},
+		"cycle_budget_seconds": {float64(*muninScrapeInterval), cycle.Seconds()},
+	}
+	for resource, v := range resources {
+		ch <- prometheus.MustNewConstMetric(lc.limit, prometheus.GaugeValue, v[0], resource)
+		ch <- prometheus.MustNewConstMetric(lc.usage, prometheus.GaugeValue, v[1], resource)
+	}
+	// waiting callers are queued without limit
+	ch <- prometheus.MustNewConstMetric(lc.usage, prometheus.GaugeValue, float64(waiting), "connection_waiters")
+
+	catalogMu.RLock()
+	catalogEntries := len(catalog)
+	catalogMu.RUnlock()
+	nodesMu.RLock()
+	nodeEntries := len(nodes)
+	nodesMu.RUnlock()
+	ch <- prometheus.MustNewConstMetric(lc.cache, prometheus.GaugeValue, float64(catalogEntries), "catalog")
+	ch <- prometheus.MustNewConstMetric(lc.cache, prometheus.GaugeValue, float64(nodeEntries), "nodes")
+	ch <- prometheus.MustNewConstMetric(lc.cache, prometheus.GaugeValue, float64(spoolSeries), "spool_series")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	loadtestURL      = flag.String("loadtest.url", "http://localhost:8080/metrics", "Metrics URL of the exporter to load test.")
+	loadtestScrapers = flag.Int("loadtest.scrapers", 10, "Number of concurrent simulated Prometheus scrapers.")
+	loadtestInterval = flag.Duration("loadtest.interval", 15*time.Second, "Scrape interval of each simulated scraper.")
+	loadtestDuration = flag.Duration("loadtest.duration", time.Minute, "How long to run the load test.")
+)
+
+type scrapeResult struct {
+	latency time.Duration
+	bytes   int64
+	err     error
+}
+
+// loadtest simulates concurrent Prometheus servers scraping a running
+// exporter and reports scrape latencies as well as the exporter's CPU and
+// memory usage taken from its own process metrics. It returns the exit
+// code: 1 if any scrape failed.
+func loadtest() int {
+	log.Printf("Load testing %s with %d scrapers every %s for %s",
+		*loadtestURL, *loadtestScrapers, *loadtestInterval, *loadtestDuration)
+
+	httpClient := &http.Client{Timeout: *loadtestInterval}
+	before := processMetrics(httpClient)
+
+	var (
+		mu      sync.Mutex
+		results []scrapeResult
+		wg      sync.WaitGroup
+	)
+	deadline := time.Now().Add(*loadtestDuration)
+	for i := 0; i < *loadtestScrapers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// spread the scrapers over the interval like independent servers
+			time.Sleep(time.Duration(i) * *loadtestInterval / time.Duration(*loadtestScrapers))
+			for time.Now().Before(deadline) {
+				result := scrapeOnce(httpClient)
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+				time.Sleep(*loadtestInterval - result.latency)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	after := processMetrics(httpClient)
+	cpuSeconds := after["process_cpu_seconds_total"] - before["process_cpu_seconds_total"]
+	return reportLoadtest(results, cpuSeconds, after["process_resident_memory_bytes"])
+}
+
+func scrapeOnce(httpClient *http.Client) scrapeResult {
+	start := time.Now()
+	resp, err := httpClient.Get(*loadtestURL)
+	if err != nil {
+		return scrapeResult{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s", resp.Status)
+	}
+	return scrapeResult{latency: time.Since(start), bytes: n, err: err}
+}
+
+// processMetrics scrapes the exporter once and returns its process_*
+// metrics.
+func processMetrics(httpClient *http.Client) map[string]float64 {
+	values := map[string]float64{}
+	resp, err := httpClient.Get(*loadtestURL)
+	if err != nil {
+		return values
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "process_") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			values[parts[0]] = value
+		}
+	}
+	return values
+}
+
+func reportLoadtest(results []scrapeResult, cpuSeconds, rss float64) int {
+	var latencies []time.Duration
+	var failed int
+	var bytes int64
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			log.Printf("Scrape failed: %s", result.err)
+			continue
+		}
+		latencies = append(latencies, result.latency)
+		bytes += result.bytes
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("scrapes: %d, failed: %d\n", len(results), failed)
+	if len(latencies) > 0 {
+		quantile := func(q float64) time.Duration { return latencies[int(q*float64(len(latencies)-1))] }
+		fmt.Printf("latency p50: %s, p90: %s, p99: %s, max: %s\n",
+			quantile(0.5), quantile(0.9), quantile(0.99), latencies[len(latencies)-1])
+		fmt.Printf("average response size: %d bytes\n", bytes/int64(len(latencies)))
+	}
+	fmt.Printf("exporter CPU: %.2fs over %s, resident memory: %.1f MiB\n",
+		cpuSeconds, *loadtestDuration, rss/1024/1024)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
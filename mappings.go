@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+// mappingFixture is a muninmock fixture along with the series the exporter
+// is expected to expose for it, in the text exposition format with labels
+// sorted by name, e.g.
+//
+//	load_load{graphname="load",hostname="fixture",muninlabel="load",type="gauge"} 0.42
+type mappingFixture struct {
+	muninmock.Fixture
+	Expect []string `json:"expect"`
+}
+
+// mockNode is the fake munin-node dialed instead of munin-node while
+// running a mapping fixture.
+var mockNode *muninmock.Server
+
+func dialMock() (net.Conn, error) {
+	client, server := net.Pipe()
+	go mockNode.ServeConn(server)
+	return client, nil
+}
+
+// testMappings runs the mapping fixtures in files and returns the exit
+// code: 0 if all passed, 1 otherwise. Each fixture runs in a process of its
+// own, so the metrics registered for one don't leak into the next.
+func testMappings(files []string) int {
+	if len(files) == 0 {
+		fmt.Println("usage: munin_exporter test mappings <fixture>...")
+		return 1
+	}
+	if len(files) == 1 {
+		return runMappingFixture(files[0])
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Printf("Couldn't find own executable: %s", err)
+		return 1
+	}
+	flags := os.Args[1 : len(os.Args)-flag.NArg()]
+	failed := 0
+	for _, file := range files {
+		cmd := exec.Command(self, append(flags, "test", "mappings", file)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			failed++
+		}
+	}
+	fmt.Printf("%d of %d fixtures passed\n", len(files)-failed, len(files))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runMappingFixture registers and fetches the plugins of the fixture in
+// file from a fake munin-node and checks the exposition for the expected
+// series.
+func runMappingFixture(file string) int {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Printf("Couldn't read fixture: %s", err)
+		return 1
+	}
+	var fixture mappingFixture
+	if err := json.Unmarshal(raw, &fixture); err != nil {
+		log.Printf("Couldn't parse fixture %s: %s", file, err)
+		return 1
+	}
+	if fixture.Hostname == "" {
+		fixture.Hostname = "fixture"
+	}
+	mockNode = muninmock.NewServer(&fixture.Fixture)
+	*muninPipelineDepth = 0 // net.Pipe can't buffer pipelined commands
+
+	if err := connect(); err != nil {
+		log.Printf("%s: couldn't connect to fake munin-node: %s", file, err)
+		return 1
+	}
+	if err := registerMetrics(); err != nil {
+		log.Printf("%s: couldn't register metrics: %s", file, err)
+		return 1
+	}
+	if err := fetchMetrics(); err != nil {
+		log.Printf("%s: couldn't fetch metrics: %s", file, err)
+		return 1
+	}
+	exposed, err := exposition()
+	if err != nil {
+		log.Printf("%s: couldn't gather metrics: %s", file, err)
+		return 1
+	}
+
+	var missing []string
+	for _, series := range fixture.Expect {
+		if !exposed[strings.TrimSpace(series)] {
+			missing = append(missing, series)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("FAIL %s, missing:\n  %s\n", file, strings.Join(missing, "\n  "))
+		return 1
+	}
+	fmt.Printf("ok   %s\n", file)
+	return 0
+}
+
+// exposition returns the series lines of the text exposition of all
+// registered metrics.
+func exposition() (map[string]bool, error) {
+	text, err := expositionText()
+	if err != nil {
+		return nil, err
+	}
+	lines := map[string]bool{}
+	for _, line := range strings.Split(string(text), "\n") {
+		if line != "" && line[0] != '#' {
+			lines[line] = true
+		}
+	}
+	return lines, nil
+}
+
+// expositionText returns the text exposition of all registered metrics.
+func expositionText() ([]byte, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&b, family); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package munin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NormalizeAddress returns address in the host:port form Dial expects. The
+// port defaults to DefaultPort. IPv6 literals may be given bracketed, with
+// or without port, or bare without port, and may carry a zone, e.g.
+// "[fe80::1%eth0]:4949" or "fe80::1%eth0". Zones escaped as in URLs ("%25")
+// are accepted as well. unix:// addresses are returned unchanged.
+func NormalizeAddress(address string) (string, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		return address, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil { // no port
+		host, port = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), DefaultPort
+		if host == "" {
+			return "", fmt.Errorf("Missing host in address %q", address)
+		}
+	}
+	if strings.Contains(host, ":") { // IPv6 literal
+		host = strings.Replace(host, "%25", "%", 1)
+		ip := host
+		if i := strings.Index(host, "%"); i >= 0 {
+			if i == len(host)-1 {
+				return "", fmt.Errorf("Empty zone in address %q", address)
+			}
+			ip = host[:i]
+		}
+		if net.ParseIP(ip) == nil {
+			return "", fmt.Errorf("Invalid IPv6 address in %q", address)
+		}
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return "", fmt.Errorf("Invalid port in address %q: %s", address, err)
+	}
+	return net.JoinHostPort(host, port), nil
+}
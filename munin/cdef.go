@@ -0,0 +1,170 @@
+package munin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CDEF is a parsed "field.cdef" expression: a comma-separated RPN
+// expression as understood by rrdtool, computing the value munin graphs
+// from the values of the graph's fields, e.g. "bytes,8,*".
+type CDEF struct {
+	tokens []string
+}
+
+// cdefArity holds the number of values each operator pops and pushes.
+var cdefArity = map[string][2]int{
+	"+": {2, 1}, "-": {2, 1}, "*": {2, 1}, "/": {2, 1}, "%": {2, 1},
+	"LT": {2, 1}, "LE": {2, 1}, "GT": {2, 1}, "GE": {2, 1}, "EQ": {2, 1}, "NE": {2, 1},
+	"MIN": {2, 1}, "MAX": {2, 1}, "ADDNAN": {2, 1}, "IF": {3, 1}, "LIMIT": {3, 1},
+	"UN": {1, 1}, "ISINF": {1, 1}, "ABS": {1, 1}, "FLOOR": {1, 1}, "CEIL": {1, 1},
+	"SQRT": {1, 1}, "LOG": {1, 1}, "EXP": {1, 1}, "SIN": {1, 1}, "COS": {1, 1},
+	"DUP": {1, 2}, "POP": {1, 0}, "EXC": {2, 2},
+	"UNKN": {0, 1}, "INF": {0, 1}, "NEGINF": {0, 1},
+}
+
+// ParseCDEF parses expr. Tokens that are neither numbers nor operators are
+// field names.
+func ParseCDEF(expr string) (*CDEF, error) {
+	c := &CDEF{tokens: strings.Split(expr, ",")}
+	depth := 0
+	for i, token := range c.tokens {
+		token = strings.TrimSpace(token)
+		c.tokens[i] = token
+		arity, isOperator := cdefArity[token]
+		switch {
+		case token == "":
+			return nil, fmt.Errorf("Empty token in cdef %q", expr)
+		case isOperator:
+			if depth < arity[0] {
+				return nil, fmt.Errorf("Too few operands for %s in cdef %q", token, expr)
+			}
+			depth += arity[1] - arity[0]
+		default:
+			depth++
+		}
+	}
+	if depth != 1 {
+		return nil, fmt.Errorf("Cdef %q leaves %d values instead of 1", expr, depth)
+	}
+	return c, nil
+}
+
+// Eval evaluates the expression with values, the values of the graph's
+// fields. Fields without a value are unknown, NaN, as are results that
+// involve unknown values, except for UN, ISINF, IF's condition and ADDNAN.
+func (c *CDEF) Eval(values map[string]float64) float64 {
+	stack := make([]float64, 0, len(c.tokens))
+	pop := func() float64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	boolean := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	for _, token := range c.tokens {
+		if _, isOperator := cdefArity[token]; !isOperator {
+			v, err := strconv.ParseFloat(token, 64)
+			if err != nil {
+				var ok bool
+				if v, ok = values[token]; !ok {
+					v = math.NaN()
+				}
+			}
+			stack = append(stack, v)
+			continue
+		}
+		switch token {
+		case "UNKN":
+			stack = append(stack, math.NaN())
+		case "INF":
+			stack = append(stack, math.Inf(1))
+		case "NEGINF":
+			stack = append(stack, math.Inf(-1))
+		case "UN":
+			stack = append(stack, boolean(math.IsNaN(pop())))
+		case "ISINF":
+			stack = append(stack, boolean(math.IsInf(pop(), 0)))
+		case "ABS", "FLOOR", "CEIL", "SQRT", "LOG", "EXP", "SIN", "COS":
+			fn := map[string]func(float64) float64{
+				"ABS": math.Abs, "FLOOR": math.Floor, "CEIL": math.Ceil, "SQRT": math.Sqrt,
+				"LOG": math.Log, "EXP": math.Exp, "SIN": math.Sin, "COS": math.Cos,
+			}[token]
+			stack = append(stack, fn(pop()))
+		case "DUP":
+			v := pop()
+			stack = append(stack, v, v)
+		case "POP":
+			pop()
+		case "EXC":
+			b, a := pop(), pop()
+			stack = append(stack, b, a)
+		case "IF":
+			b, a, cond := pop(), pop(), pop()
+			if cond != 0 && !math.IsNaN(cond) {
+				stack = append(stack, a)
+			} else {
+				stack = append(stack, b)
+			}
+		case "LIMIT":
+			max, min, v := pop(), pop(), pop()
+			if math.IsNaN(min) || math.IsNaN(max) || v < min || v > max {
+				v = math.NaN()
+			}
+			stack = append(stack, v)
+		case "ADDNAN":
+			b, a := pop(), pop()
+			switch {
+			case math.IsNaN(a):
+				stack = append(stack, b)
+			case math.IsNaN(b):
+				stack = append(stack, a)
+			default:
+				stack = append(stack, a+b)
+			}
+		default:
+			b, a := pop(), pop()
+			if math.IsNaN(a) || math.IsNaN(b) {
+				stack = append(stack, math.NaN())
+				continue
+			}
+			var v float64
+			switch token {
+			case "+":
+				v = a + b
+			case "-":
+				v = a - b
+			case "*":
+				v = a * b
+			case "/":
+				v = a / b
+			case "%":
+				v = math.Mod(a, b)
+			case "LT":
+				v = boolean(a < b)
+			case "LE":
+				v = boolean(a <= b)
+			case "GT":
+				v = boolean(a > b)
+			case "GE":
+				v = boolean(a >= b)
+			case "EQ":
+				v = boolean(a == b)
+			case "NE":
+				v = boolean(a != b)
+			case "MIN":
+				v = math.Min(a, b)
+			case "MAX":
+				v = math.Max(a, b)
+			}
+			stack = append(stack, v)
+		}
+	}
+	return stack[0]
+}
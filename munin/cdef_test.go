@@ -0,0 +1,45 @@
+package munin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCDEF(t *testing.T) {
+	for _, expr := range []string{"", "a,b", "a,+", "a,,8,*", "a,8,*,*"} {
+		if _, err := ParseCDEF(expr); err == nil {
+			t.Errorf("ParseCDEF(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCDEFEval(t *testing.T) {
+	values := map[string]float64{"rx": 10, "tx": 4, "gone": math.NaN()}
+	for _, tt := range []struct {
+		expr string
+		want float64
+	}{
+		{"rx,8,*", 80},
+		{"rx,tx,-", 6},
+		{"rx,tx,/", 2.5},
+		{"rx, tx ,MAX", 10},
+		{"rx,tx,GT,rx,tx,IF", 10},
+		{"rx,0,5,LIMIT", math.NaN()},
+		{"gone,UN", 1},
+		{"gone,rx,+", math.NaN()},
+		{"gone,rx,ADDNAN", 10},
+		{"missing,1,+", math.NaN()},
+		{"rx,DUP,*", 100},
+		{"rx,tx,EXC,-", -6},
+	} {
+		c, err := ParseCDEF(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCDEF(%q): %s", tt.expr, err)
+			continue
+		}
+		got := c.Eval(values)
+		if got != tt.want && !(math.IsNaN(got) && math.IsNaN(tt.want)) {
+			t.Errorf("%q evaluates to %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Package munin implements a client for the munin-node protocol.
+//
+// A Client wraps a single connection to munin-node. Commands are executed
+// one at a time; a Client is safe for concurrent use, but concurrent calls
+// are serialized on the connection. Every method takes a context whose
+// deadline and cancellation are applied to the underlying connection.
+//
+// A Session adds the connection management long-running clients need on
+// top of that: capability negotiation on every new connection and
+// reconnecting with backoff when munin-node drops the connection.
+package munin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the TCP port munin-node listens on by default.
+const DefaultPort = "4949"
+
+const unixPrefix = "unix://"
+
+var banner = regexp.MustCompile(`# munin node at (.*)`)
+
+// Option configures a Client.
+type Option func(*options)
+
+type options struct {
+	dialer       *net.Dialer
+	tlsConfig    *tls.Config
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	trace        io.Writer
+}
+
+// WithDialer sets the dialer used by Dial. The default is a zero
+// net.Dialer.
+func WithDialer(d *net.Dialer) Option {
+	return func(o *options) { o.dialer = d }
+}
+
+// WithTLS makes the client upgrade the connection using the starttls
+// command before issuing any other command.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) { o.tlsConfig = config }
+}
+
+// WithReadTimeout bounds how long the client waits for each line of a
+// response, so a plugin hanging mid-output can't stall a command forever.
+// The connection must be discarded after a timeout.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *options) { o.readTimeout = d }
+}
+
+// WithWriteTimeout bounds how long sending a command may take.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *options) { o.writeTimeout = d }
+}
+
+// WithTrace makes the client write every line it sends, prefixed with
+// "> ", and every line it receives, prefixed with "< ", to w. Each line
+// is passed to w in a single Write. Lines exchanged after a TLS upgrade
+// are traced in plain text.
+func WithTrace(w io.Writer) Option {
+	return func(o *options) { o.trace = w }
+}
+
+// Client is a connection to a munin-node.
+type Client struct {
+	mu           sync.Mutex
+	conn         net.Conn
+	reader       *bufio.Reader
+	hostname     string
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	trace        io.Writer
+}
+
+// Dial connects to the munin-node at address and reads its banner.
+// Addresses of the form unix:///path are dialed as Unix domain sockets,
+// everything else as TCP as described for NormalizeAddress.
+func Dial(ctx context.Context, address string, opts ...Option) (*Client, error) {
+	o := options{dialer: &net.Dialer{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp"
+	if strings.HasPrefix(address, unixPrefix) {
+		network, address = "unix", strings.TrimPrefix(address, unixPrefix)
+	}
+	conn, err := o.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(ctx, conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient creates a client on an already established connection, reads
+// the banner and, if requested, upgrades the connection to TLS. The client
+// takes ownership of conn.
+func NewClient(ctx context.Context, conn net.Conn, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Client{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		readTimeout:  o.readTimeout,
+		writeTimeout: o.writeTimeout,
+		trace:        o.trace,
+	}
+	defer c.watch(ctx)()
+
+	head, err := c.readLine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matches := banner.FindStringSubmatch(head)
+	if len(matches) != 2 { // expect: # munin node at <hostname>
+		return nil, fmt.Errorf("Unexpected line: %s", head)
+	}
+	c.hostname = strings.TrimRight(matches[1], "\r")
+
+	if o.tlsConfig != nil {
+		if err := c.startTLS(ctx, o.tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Client) startTLS(ctx context.Context, config *tls.Config) error {
+	resp, err := c.exchange(ctx, "starttls")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp, "TLS OK") {
+		return fmt.Errorf("munin-node refused starttls: %s", resp)
+	}
+
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %s", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Hostname returns the hostname announced in the node's banner.
+func (c *Client) Hostname() string {
+	return c.hostname
+}
+
+// Close closes the connection without saying goodbye to munin-node.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Quit sends the quit command and closes the connection, so munin-node
+// frees its worker right away.
+func (c *Client) Quit(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.watch(ctx)()
+
+	err := c.write(ctx, "quit")
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Caps announces the capabilities the client supports and returns the
+// subset munin-node supports as well.
+func (c *Client) Caps(ctx context.Context, caps ...string) ([]string, error) {
+	resp, err := c.Line(ctx, strings.TrimSpace("cap "+strings.Join(caps, " ")))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(resp)
+	if len(fields) == 0 || fields[0] != "cap" {
+		return nil, fmt.Errorf("Unexpected cap response: %s", resp)
+	}
+	return fields[1:], nil
+}
+
+// Nodes returns the names of the nodes, virtual or not, served by
+// munin-node.
+func (c *Client) Nodes(ctx context.Context) ([]string, error) {
+	lines, err := c.Lines(ctx, "nodes")
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, line := range lines {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		nodes = append(nodes, line)
+	}
+	return nodes, nil
+}
+
+// List returns the plugins of node, or of munin-node's default node if
+// node is empty.
+func (c *Client) List(ctx context.Context, node string) ([]string, error) {
+	resp, err := c.Line(ctx, strings.TrimSpace("list "+node))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) > 0 && resp[0] == '#' { // # not expected here
+		return nil, fmt.Errorf("Error getting items: %s", resp)
+	}
+	return strings.Fields(resp), nil
+}
+
+// Config returns the configuration of plugin. For multigraph plugins use
+// Lines with SplitMultigraph and ParseConfig instead.
+func (c *Client) Config(ctx context.Context, plugin string) (*Config, error) {
+	lines, err := c.Lines(ctx, "config "+plugin)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(lines)
+}
+
+// Fetch returns the current values of plugin. Lines that can't be parsed
+// are skipped; use Lines with ParseFetchLine to inspect them, and
+// SplitMultigraph to tell the graphs of multigraph plugins apart.
+func (c *Client) Fetch(ctx context.Context, plugin string) ([]Value, error) {
+	lines, err := c.Lines(ctx, "fetch "+plugin)
+	if err != nil {
+		return nil, err
+	}
+	var values []Value
+	for _, line := range lines {
+		value, err := ParseFetchLine(line)
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Spoolfetch returns the raw output of the spoolfetch command offered by
+// munin-async, i.e. everything spooled since the given time.
+func (c *Client) Spoolfetch(ctx context.Context, since time.Time) ([]string, error) {
+	return c.Lines(ctx, fmt.Sprintf("spoolfetch %d", since.Unix()))
+}
+
+// Version returns the version of munin-node.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	resp, err := c.Line(ctx, "version")
+	if err != nil {
+		return "", err
+	}
+	// expect: munins node on <hostname> version: <version>
+	if i := strings.LastIndex(resp, "version: "); i >= 0 {
+		return strings.TrimSpace(resp[i+len("version: "):]), nil
+	}
+	return resp, nil
+}
+
+// Line sends cmd and returns its single line response without the
+// trailing newline.
+func (c *Client) Line(ctx context.Context, cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.watch(ctx)()
+
+	return c.exchange(ctx, cmd)
+}
+
+// Lines sends cmd and returns its multi-line response up to, but not
+// including, the terminating "." line.
+func (c *Client) Lines(ctx context.Context, cmd string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.watch(ctx)()
+
+	if err := c.write(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return c.readLines(ctx, cmd)
+}
+
+// Pipeline sends all cmds at once and returns their multi-line responses in
+// order, saving a round trip per command. Not every munin-node copes with
+// pipelined commands, so use it only with nodes known to. On error, the
+// responses read completely so far are returned.
+func (c *Client) Pipeline(ctx context.Context, cmds ...string) ([][]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.watch(ctx)()
+
+	if err := c.write(ctx, cmds...); err != nil {
+		return nil, err
+	}
+	responses := make([][]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		lines, err := c.readLines(ctx, cmd)
+		if err != nil {
+			return responses, err
+		}
+		responses = append(responses, lines)
+	}
+	return responses, nil
+}
+
+// exchange writes cmd and reads the first line of the response. It returns
+// io.EOF if munin-node closed the connection.
+func (c *Client) exchange(ctx context.Context, cmd string) (string, error) {
+	if err := c.write(ctx, cmd); err != nil {
+		return "", err
+	}
+	return c.readLine(ctx)
+}
+
+// write sends cmds, one per line, in a single write.
+func (c *Client) write(ctx context.Context, cmds ...string) error {
+	if err := c.setDeadline(ctx, c.conn.SetWriteDeadline, c.writeTimeout); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		c.traceLine("> ", cmd)
+	}
+	_, err := io.WriteString(c.conn, strings.Join(cmds, "\n")+"\n")
+	return err
+}
+
+func (c *Client) traceLine(prefix, line string) {
+	if c.trace != nil {
+		io.WriteString(c.trace, prefix+line+"\n")
+	}
+}
+
+// readLines reads a multi-line response to cmd up to the terminating "."
+// line.
+func (c *Client) readLines(ctx context.Context, cmd string) ([]string, error) {
+	line, err := c.readLine(ctx)
+	var lines []string
+	for err == nil && line != "." {
+		lines = append(lines, line)
+		line, err = c.readLine(ctx)
+	}
+	if err != nil && len(lines) > 0 {
+		return lines, fmt.Errorf("Incomplete response to %q: %w", cmd, err)
+	}
+	return lines, err
+}
+
+func (c *Client) readLine(ctx context.Context) (string, error) {
+	if err := c.setDeadline(ctx, c.conn.SetReadDeadline, c.readTimeout); err != nil {
+		return "", err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		if line != "" {
+			c.traceLine("< ", line+" [incomplete: "+err.Error()+"]")
+		}
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	c.traceLine("< ", line)
+	return line, nil
+}
+
+// setDeadline applies timeout, capped by the deadline of ctx, using set.
+// Without a timeout the deadline set by watch stays in effect.
+func (c *Client) setDeadline(ctx context.Context, set func(time.Time) error, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return set(deadline)
+}
+
+// watch applies the deadline and cancellation of ctx to the connection
+// until the returned function is called.
+func (c *Client) watch(ctx context.Context) func() {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0)) // unblock pending reads and writes
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
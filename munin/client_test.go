@@ -0,0 +1,139 @@
+package munin_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+// serve runs a muninmock server for f and returns its address.
+func serve(t *testing.T, f *muninmock.Fixture) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go muninmock.NewServer(f).Serve(l)
+	return l.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	addr := serve(t, &muninmock.Fixture{
+		Hostname: "testhost",
+		Version:  "2.0.49",
+		Caps:     []string{"multigraph"},
+		Nodes:    []string{"testhost"},
+		Plugins: map[string]muninmock.Plugin{
+			"load": {
+				Config: []string{"graph_title Load average", "load.label load"},
+				Fetch:  [][]string{{"load.value 0.42"}, {"load.value U"}},
+			},
+		},
+	})
+	c, err := munin.Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if got := c.Hostname(); got != "testhost" {
+		t.Errorf("Hostname() = %q, want testhost", got)
+	}
+	if caps, err := c.Caps(ctx, "multigraph", "dirtyconfig"); err != nil || !reflect.DeepEqual(caps, []string{"multigraph"}) {
+		t.Errorf("Caps() = %v, %v, want [multigraph]", caps, err)
+	}
+	if version, err := c.Version(ctx); err != nil || version != "2.0.49" {
+		t.Errorf("Version() = %q, %v, want 2.0.49", version, err)
+	}
+	if plugins, err := c.List(ctx, ""); err != nil || !reflect.DeepEqual(plugins, []string{"load"}) {
+		t.Errorf("List() = %v, %v, want [load]", plugins, err)
+	}
+	config, err := c.Config(ctx, "load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Fields["load"]["label"]; got != "load" {
+		t.Errorf("label of load = %q, want load", got)
+	}
+	values, err := c.Fetch(ctx, "load")
+	if err != nil || len(values) != 1 || values[0].Field != "load" || values[0].Value != 0.42 {
+		t.Errorf("first Fetch() = %+v, %v, want load = 0.42", values, err)
+	}
+	values, err = c.Fetch(ctx, "load")
+	if err != nil || len(values) != 1 || !values[0].Unknown {
+		t.Errorf("second Fetch() = %+v, %v, want load unknown", values, err)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	ctx := context.Background()
+	addr := serve(t, &muninmock.Fixture{Hostname: "testhost", Nodes: []string{"testhost"}, CloseAfter: 1})
+	c, err := munin.Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Nodes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Nodes(ctx)
+	if !munin.ConnectionLost(err) {
+		t.Errorf("ConnectionLost(%v) = false after munin-node closed the connection", err)
+	}
+	if munin.IsTimeout(err) {
+		t.Errorf("IsTimeout(%v) = true", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	addr := serve(t, &muninmock.Fixture{
+		Hostname:   "testhost",
+		Caps:       []string{"multigraph"},
+		Nodes:      []string{"testhost"},
+		CloseAfter: 3,
+	})
+	connects := 0
+	s := &munin.Session{
+		Address:   addr,
+		Caps:      []string{"multigraph", "dirtyconfig"},
+		Backoff:   munin.Backoff{Attempts: 2, Initial: time.Millisecond},
+		OnConnect: func(c *munin.Client, caps []string) { connects++ },
+	}
+	defer s.Quit(ctx)
+
+	nodes := func(c *munin.Client) error {
+		_, err := c.Nodes(ctx)
+		return err
+	}
+	// cap and two nodes use up the first connection, the third nodes is
+	// run again on a second one
+	for i := 0; i < 3; i++ {
+		if err := s.Do(ctx, nodes); err != nil {
+			t.Fatalf("Do() #%d = %v", i+1, err)
+		}
+	}
+	if connects != 2 {
+		t.Errorf("connected %d times, want 2", connects)
+	}
+	if caps := s.Capabilities(); !reflect.DeepEqual(caps, []string{"multigraph"}) {
+		t.Errorf("Capabilities() = %v, want [multigraph]", caps)
+	}
+
+	s.Backoff.Attempts = 1
+	if err := s.Do(ctx, nodes); err != nil {
+		t.Fatalf("Do() on the second connection = %v", err)
+	}
+	err := s.Do(ctx, nodes)
+	var giveUp *munin.GiveUpError
+	if !errors.As(err, &giveUp) || !munin.ConnectionLost(err) {
+		t.Errorf("Do() without attempts left = %v, want a GiveUpError of a lost connection", err)
+	}
+}
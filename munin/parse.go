@@ -0,0 +1,154 @@
+package munin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the parsed output of the config command.
+type Config struct {
+	// Graph holds the graph attributes, e.g. graph_title.
+	Graph map[string]string
+	// Fields holds the attributes of each field, e.g. Fields["user"]["label"].
+	Fields map[string]map[string]string
+}
+
+// Value is a single field value as returned by fetch.
+type Value struct {
+	Field string
+	Value float64
+	// Timestamp is the time the value was sampled at, if munin-node sent
+	// one as in "field.value <epoch>:<value>", e.g. in spoolfetch output.
+	Timestamp time.Time
+	// Unknown is set for values reported as "U", the plugin couldn't take
+	// a reading. Value is NaN then.
+	Unknown bool
+}
+
+// Section is the part of a response belonging to a single graph.
+type Section struct {
+	Graph string
+	Lines []string
+}
+
+// SplitMultigraph splits the response of plugin into the sections started
+// by "multigraph <graph>" lines, as sent by multigraph plugins once the
+// multigraph capability has been negotiated. Lines before the first such
+// line belong to the graph named after plugin. Empty sections are dropped.
+func SplitMultigraph(plugin string, lines []string) []Section {
+	var sections []Section
+	current := Section{Graph: plugin}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "multigraph ") {
+			if len(current.Lines) > 0 {
+				sections = append(sections, current)
+			}
+			current = Section{Graph: strings.TrimSpace(strings.TrimPrefix(line, "multigraph "))}
+			continue
+		}
+		current.Lines = append(current.Lines, line)
+	}
+	if len(current.Lines) > 0 {
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+// ParseConfig parses the lines of a config response. Comments are ignored.
+func ParseConfig(lines []string) (*Config, error) {
+	config := &Config{
+		Graph:  map[string]string{},
+		Fields: map[string]map[string]string{},
+	}
+	for _, line := range lines {
+		if line == "" || line[0] == '#' { // here it's just a comment, so ignore it
+			continue
+		}
+		key, value, err := ParseConfigLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		keyParts := strings.SplitN(key, ".", 2)
+		if len(keyParts) > 1 { // it's a metric config (metric.label etc)
+			if _, ok := config.Fields[keyParts[0]]; !ok {
+				config.Fields[keyParts[0]] = map[string]string{}
+			}
+			config.Fields[keyParts[0]][keyParts[1]] = value
+		} else {
+			config.Graph[key] = value
+		}
+	}
+	return config, nil
+}
+
+// ParseConfigLine splits a config line into its key and value. The value
+// is everything after the whitespace following the key, kept verbatim
+// apart from trailing whitespace, so colons, quotes and runs of spaces
+// survive. A value enclosed in double quotes has the quotes removed and
+// the escapes \", \\, \n and \t resolved.
+func ParseConfigLine(line string) (key, value string, err error) {
+	line = strings.TrimSpace(line)
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return "", "", fmt.Errorf("Line unexpected: %s", line)
+	}
+	key, value = line[:i], strings.TrimLeft(line[i:], " \t")
+	if value == "" {
+		return "", "", fmt.Errorf("Line unexpected: %s", line)
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = unescape(value[1 : len(value)-1])
+	}
+	return key, value, nil
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default: // \" and \\ as well as unknown escapes yield the character
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// ParseFetchLine parses a single "field.value <value>" or
+// "field.value <epoch>:<value>" line of a fetch or spoolfetch response.
+func ParseFetchLine(line string) (Value, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 2 || !strings.HasSuffix(parts[0], ".value") {
+		return Value{}, fmt.Errorf("unexpected line: %s", line)
+	}
+	field := strings.TrimSuffix(parts[0], ".value")
+	raw := parts[1]
+	var timestamp time.Time
+	if i := strings.Index(raw, ":"); i >= 0 {
+		epoch, err := strconv.ParseInt(raw[:i], 10, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("Couldn't parse timestamp in line %s, malformed?", line)
+		}
+		timestamp, raw = time.Unix(epoch, 0), raw[i+1:]
+	}
+	if raw == "U" {
+		return Value{Field: field, Value: math.NaN(), Timestamp: timestamp, Unknown: true}, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Value{}, fmt.Errorf("Couldn't parse value in line %s, malformed?", line)
+	}
+	return Value{Field: field, Value: value, Timestamp: timestamp}, nil
+}
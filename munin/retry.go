@@ -0,0 +1,50 @@
+package munin
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Backoff tells how often and how fast a command is retried after the
+// connection was lost or couldn't be established.
+type Backoff struct {
+	// Attempts is the maximum number of attempts, including the first
+	// one. Less than 1 means 1.
+	Attempts int
+	// Initial is the delay before the second attempt, doubled for every
+	// further attempt.
+	Initial time.Duration
+	// Max caps the delay between attempts; 0 leaves it uncapped.
+	Max time.Duration
+}
+
+// Delay returns the delay before the given attempt, counting from 2.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 2; i < attempt && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// ConnectionLost reports whether err means munin-node dropped the
+// connection, as it does when restarted, so the command can be retried on
+// a new connection.
+func ConnectionLost(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// IsTimeout reports whether err is a timeout, whether of a read or write
+// timeout option or of a context deadline. The connection must be
+// discarded after a timeout, since the response may still arrive on it.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
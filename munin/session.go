@@ -0,0 +1,188 @@
+package munin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultBackoff is the Backoff of a Session that doesn't set one.
+var DefaultBackoff = Backoff{Attempts: 5, Initial: time.Second, Max: 30 * time.Second}
+
+// GiveUpError is returned by Session.Do once all attempts failed because
+// the connection was lost or couldn't be established.
+type GiveUpError struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *GiveUpError) Error() string {
+	return fmt.Sprintf("Giving up after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *GiveUpError) Unwrap() error {
+	return e.Err
+}
+
+// Session keeps a connection to munin-node for running commands on,
+// taking care of what every long-running munin client needs: it dials
+// lazily, negotiates capabilities on every new connection, discards
+// connections after timeouts, and reconnects with backoff when munin-node
+// drops the connection, e.g. because it's restarted. A Session is safe for
+// concurrent use; commands are serialized on its connection.
+type Session struct {
+	// Address is the munin-node to dial, see Dial.
+	Address string
+	// Options configure every Client of the session.
+	Options []Option
+	// Caps are the capabilities announced on every new connection.
+	Caps []string
+	// Backoff is applied when a connection is lost or can't be
+	// established. The zero value means DefaultBackoff.
+	Backoff Backoff
+	// Dial, if set, establishes connections instead of Dial with Address
+	// and Options, e.g. to dial through a proxy.
+	Dial func(ctx context.Context) (*Client, error)
+	// OnConnect, if set, is called with every new connection, after the
+	// capabilities were negotiated.
+	OnConnect func(c *Client, caps []string)
+
+	mu     sync.Mutex
+	client *Client
+	caps   []string
+}
+
+// Do runs fn with the session's connection, dialing one if needed. If the
+// connection is lost or can't be established, fn is run again on a new
+// connection, up to Backoff.Attempts attempts in total, waiting according
+// to Backoff in between. fn must therefore be safe to repeat. After a
+// timeout, the connection is discarded and the error returned.
+func (s *Session) Do(ctx context.Context, fn func(c *Client) error) error {
+	backoff := s.Backoff
+	if backoff == (Backoff{}) {
+		backoff = DefaultBackoff
+	}
+	return s.DoWithBackoff(ctx, backoff, fn)
+}
+
+// DoWithBackoff is like Do, but retries according to backoff instead of
+// the session's Backoff, for commands that deserve more or less patience.
+func (s *Session) DoWithBackoff(ctx context.Context, backoff Backoff, fn func(c *Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff.Delay(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		c, err := s.connect(ctx)
+		if err == nil {
+			if err = fn(c); err == nil {
+				return nil
+			}
+			if IsTimeout(err) || ConnectionLost(err) {
+				s.discard()
+			}
+			if !ConnectionLost(err) {
+				return err
+			}
+		} else if ctx.Err() != nil {
+			return err
+		}
+		if attempt >= backoff.Attempts {
+			return &GiveUpError{Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// Connect dials munin-node unless the session is connected already,
+// without retrying, e.g. to find out whether munin-node can be reached
+// before relying on it.
+func (s *Session) Connect(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.connect(ctx)
+	return err
+}
+
+// connect returns the session's connection, dialing one if needed.
+func (s *Session) connect(ctx context.Context) (*Client, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+	var (
+		c   *Client
+		err error
+	)
+	if s.Dial != nil {
+		c, err = s.Dial(ctx)
+	} else {
+		c, err = Dial(ctx, s.Address, s.Options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.caps = nil
+	if len(s.Caps) > 0 {
+		caps, err := c.Caps(ctx, s.Caps...)
+		if ConnectionLost(err) || IsTimeout(err) {
+			c.Close()
+			return nil, err
+		}
+		s.caps = caps // nodes without capabilities answer with an error
+	}
+	if s.OnConnect != nil {
+		s.OnConnect(c, s.caps)
+	}
+	s.client = c
+	return c, nil
+}
+
+func (s *Session) discard() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// Capabilities returns the capabilities munin-node agreed to on the
+// current connection, out of Caps.
+func (s *Session) Capabilities() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.caps...)
+}
+
+// Close closes the current connection, if any, without saying goodbye,
+// e.g. because it can't be trusted anymore. The session dials again when
+// used afterwards.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// Quit says goodbye to munin-node and closes the current connection, if
+// any. The session dials again when used afterwards.
+func (s *Session) Quit(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Quit(ctx)
+	s.client = nil
+	return err
+}
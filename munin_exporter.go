@@ -1,19 +1,24 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pvdh/munin_exporter/munin"
 )
 
 const (
@@ -24,270 +29,805 @@ const (
 var (
 	listeningAddress    = flag.String("listeningAddress", ":8080", "Address on which to expose Prometheus metrics.")
 	listeningPath       = flag.String("listeningPath", "/metrics", "Path on which to expose Prometheus metrics.")
-	muninAddress        = flag.String("muninAddress", "localhost:4949", "munin-node address.")
+	sdPath              = flag.String("sdPath", "/sd", "Path on which to expose discovered munin nodes in Prometheus http_sd format.")
+	catalogPath         = flag.String("catalogPath", "/catalog", "Path on which to expose the catalog of generated metrics as JSON.")
+	metadataPath        = flag.String("metadataPath", "/api/v1/metadata", "Path on which to expose the type and help of generated metrics in the format of Prometheus' metadata API.")
+	muninAddress        = flag.String("muninAddress", "localhost:4949", "munin-node address, either host:port or unix:///path/to/socket.")
 	muninScrapeInterval = flag.Int("muninScrapeInterval", 60, "Interval in seconds between scrapes.")
-	globalConn          net.Conn
-	hostname            string
+	hostname            string // guarded by nodeMu
 	graphs              []string
+	discovered          []string
+	graphCategories     = map[string]string{}
+	pluginCategories    = map[string]string{} // category of the first graph of each plugin
+	graphVLabels        = map[string]string{}
+	nodes               []string
+	nodesMu             sync.RWMutex
 	gaugePerMetric      map[string]*prometheus.GaugeVec
 	counterPerMetric    map[string]*prometheus.CounterVec
-	muninBanner         *regexp.Regexp
+	rediscoveryPending  int32             // accessed atomically
+	pluginStatus        map[string]string // per-plugin outcome of the last fetch
+
+	commandErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "munin_command_errors_total",
+			Help: "Number of munin commands that failed, by reason.",
+		},
+		[]string{"hostname", "command", "reason"},
+	)
 )
 
 func init() {
-	flag.Parse()
-	var err error
 	gaugePerMetric = map[string]*prometheus.GaugeVec{}
 	counterPerMetric = map[string]*prometheus.CounterVec{}
-	muninBanner = regexp.MustCompile(`# munin node at (.*)`)
+	registry.MustRegister(commandErrors)
+}
 
-	err = connect()
+func registerHandlers() {
+	http.Handle(*listeningPath, metricsHandler())
+	http.HandleFunc(*listeningPath+"/", serveView)
+	http.HandleFunc(*sdPath, serveSD)
+	http.HandleFunc(*catalogPath, serveCatalog)
+	http.HandleFunc(*metadataPath, serveMetadata)
+	http.HandleFunc("/debug/trace", serveTrace)
+	http.HandleFunc("/debug/lastfetch", serveLastFetch)
+	http.HandleFunc("/-/scrape", serveScrape)
+	http.HandleFunc("/-/healthy", serveHealthy)
+	http.HandleFunc("/-/ready", serveReady)
+}
+
+// serveStatus serves the handlers registered with http.DefaultServeMux
+// until the server fails.
+func serveStatus() error {
+	handler, err := withAuth(http.DefaultServeMux)
 	if err != nil {
-		log.Fatalf("Could not connect to %s: %s", *muninAddress, err)
+		return fmt.Errorf("Could not set up authentication: %s", err)
+	}
+	listener, err := net.Listen("tcp", *listeningAddress)
+	if err != nil {
+		return fmt.Errorf("Could not listen on %s: %s", *listeningAddress, err)
+	}
+	listener, err = allowListener(listener)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("Could not set up allowlist: %s", err)
 	}
-}
 
-func serveStatus() {
-	http.Handle(*listeningPath, prometheus.Handler())
-	http.ListenAndServe(*listeningAddress, nil)
+	server := &http.Server{Handler: handler}
+	if *webTLSCertFile == "" {
+		return server.Serve(listener)
+	}
+	server.TLSConfig, err = webTLSConfig()
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("Could not set up TLS: %s", err)
+	}
+	return server.ServeTLS(listener, *webTLSCertFile, *webTLSKeyFile)
 }
 
+// connect makes sure a connection to munin-node can be established.
 func connect() (err error) {
-	log.Printf("Connecting...")
-	globalConn, err = net.Dial(proto, *muninAddress)
+	s, err := muninPool.get()
 	if err != nil {
 		return
 	}
-	log.Printf("connected!")
+	muninPool.release(s, nil)
+	return
+}
 
-	reader := bufio.NewReader(globalConn)
-	head, err := reader.ReadString('\n')
+// connectClient opens a new connection for a pooled session, which
+// negotiates the capabilities on it.
+func connectClient() (c *munin.Client, err error) {
+	log.Printf("Connecting...")
+	c, err = newClient()
 	if err != nil {
 		return
 	}
-
-	matches := muninBanner.FindStringSubmatch(head)
-	if len(matches) != 2 { // expect: # munin node at <hostname>
-		return fmt.Errorf("Unexpected line: %s", head)
+	banner := c.Hostname()
+	label := labelHostname(banner)
+	log.Printf("Found hostname: %s", banner)
+	version := queryVersion(c)
+
+	// the pool connects from concurrent registrations and fetches
+	nodeMu.Lock()
+	previousHostname, previousVersion := bannerHostname, nodeVersion
+	bannerHostname, hostname = banner, label
+	if version != "" {
+		nodeVersion = version
 	}
-	hostname = matches[1]
-	log.Printf("Found hostname: %s", hostname)
+	nodeMu.Unlock()
+	updateNodeInfo(version)
+	detectRestart(previousHostname, previousVersion, banner, version)
 	return
 }
 
-func muninCommand(cmd string) (reader *bufio.Reader, err error) {
-	reader = bufio.NewReader(globalConn)
-
-	fmt.Fprintf(globalConn, cmd+"\n")
+// newClient opens a new session with munin-node as configured by the
+// munin.* flags.
+func newClient() (c *munin.Client, err error) {
+	start := time.Now()
+	conn, err := dialMunin()
+	if err != nil {
+		return
+	}
+	conn = countingConn{conn}
+	nodeMu.Lock()
+	peerAddress = conn.RemoteAddr()
+	nodeMu.Unlock()
+	observePhase("dial", start)
+	log.Printf("connected!")
 
-	_, err = reader.Peek(1)
-	switch err {
-	case io.EOF:
-		log.Printf("not connected anymore, closing connection")
-		globalConn.Close()
-		for {
-			err = connect()
-			if err == nil {
-				break
-			}
-			log.Printf("Couldn't reconnect: %s", err)
-			time.Sleep(retryInterval * time.Second)
+	opts := []munin.Option{
+		munin.WithReadTimeout(*muninReadTimeout),
+		munin.WithWriteTimeout(*muninWriteTimeout),
+	}
+	if trace := sessionTrace(); trace != nil {
+		opts = append(opts, munin.WithTrace(trace))
+	}
+	if *muninTLS && *replayFile == "" { // recordings are in plain text
+		config, err := muninTLSConfig()
+		if err != nil {
+			conn.Close()
+			return nil, err
 		}
-
-		return muninCommand(cmd)
-	case nil: //no error
-		break
-	default:
-		log.Fatalf("Unexpected error: %s", err)
+		opts = append(opts, munin.WithTLS(config))
 	}
-
+	banner := time.Now()
+	c, err = munin.NewClient(context.Background(), conn, opts...)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	observePhase("banner", banner)
+	connectDuration.WithLabelValues(c.Hostname()).Observe(time.Since(start).Seconds())
 	return
 }
 
-func muninList() (items []string, err error) {
-	munin, err := muninCommand("list")
+// muninDo runs the command cmd via fn on a pooled munin session. If
+// munin-node closed the connection, the session reconnects and runs fn
+// again as the retry policy of cmd allows. A command that timed out is
+// aborted and the connection is dropped, since the rest of the response may
+// still arrive on it.
+func muninDo(cmd string, fn func(c *munin.Client) error) (err error) {
+	s, err := muninPool.get()
 	if err != nil {
-		log.Printf("couldn't get list")
-		return
+		return unreachableError{err}
 	}
+	attempt := 0
+	err = s.DoWithBackoff(context.Background(), commandRetryPolicy(cmd), func(c *munin.Client) error {
+		if attempt++; attempt > 1 {
+			// an idle timeout or a firewall drops connections as
+			// well, so only a changed hostname or version counts as
+			// a restart
+			log.Printf("Reconnected, running %s again", cmd)
+		}
+		start := time.Now()
+		err := fn(c)
+		observeCommand(cmd, start)
+		if munin.ConnectionLost(err) {
+			log.Printf("not connected anymore, closing connection")
+		}
+		return err
+	})
+	muninPool.release(s, err)
+	var giveUp *munin.GiveUpError
+	switch {
+	case errors.As(err, &giveUp):
+		commandErrors.WithLabelValues(nodeHostname(), cmd, "retries_exhausted").Inc()
+		err = fmt.Errorf("Giving up on %s after %d attempts: %s", cmd, giveUp.Attempts, giveUp.Err)
+		log.Print(err)
+		return unreachableError{err}
+	case munin.IsTimeout(err):
+		log.Printf("%s timed out, dropping connection", cmd)
+		commandErrors.WithLabelValues(nodeHostname(), cmd, "timeout").Inc()
+	}
+	return
+}
 
-	response, err := munin.ReadString('\n') // we are only interested in the first line
+func muninList() (items []string, err error) {
+	defer observePhase("list", time.Now())
+	err = muninDo("list", func(c *munin.Client) (err error) {
+		items, err = c.List(context.Background(), "")
+		return
+	})
 	if err != nil {
-		log.Printf("couldn't read response")
+		log.Printf("couldn't get list")
 		return
 	}
+	return filterPlugins(items)
+}
 
-	if response[0] == '#' { // # not expected here
-		err = fmt.Errorf("Error getting items: %s", response)
+func muninNodes() (items []string, err error) {
+	err = muninDo("nodes", func(c *munin.Client) (err error) {
+		items, err = c.Nodes(context.Background())
 		return
+	})
+	if err != nil {
+		log.Printf("couldn't get nodes")
 	}
-	items = strings.Fields(strings.TrimRight(response, "\n"))
 	return
 }
 
-func muninConfig(name string) (config map[string]map[string]string, graphConfig map[string]string, err error) {
-	graphConfig = make(map[string]string)
-	config = make(map[string]map[string]string)
+// graphConfig is the configuration of one graph of a plugin; multigraph
+// plugins have several.
+type graphConfig struct {
+	graph  string
+	config *munin.Config
+}
 
-	resp, err := muninCommand("config " + name)
+func muninConfig(name string) (lines []string, err error) {
+	start := time.Now()
+	err = muninDo("config", func(c *munin.Client) (err error) {
+		lines, err = c.Lines(context.Background(), "config "+name)
+		return
+	})
+	observePhase("config", start)
 	if err != nil {
 		log.Printf("couldn't get config for %s", name)
-		return
 	}
+	return
+}
 
-	for {
-		line, err := resp.ReadString('\n')
-		if err == io.EOF {
-			log.Fatalf("unexpected EOF, retrying")
-			return muninConfig(name)
+// parseGraphConfigs parses the config response of plugin name.
+func parseGraphConfigs(name string, lines []string) (configs []graphConfig, err error) {
+	defer observePhase("parse", time.Now())
+	for _, section := range munin.SplitMultigraph(name, lines) {
+		var valid []string
+		for _, line := range section.Lines {
+			if line != "" && line[0] != '#' {
+				if _, _, err := munin.ParseConfigLine(line); err != nil {
+					if err := malformed(name, "config", err); err != nil {
+						return nil, err
+					}
+					continue
+				}
+			}
+			valid = append(valid, line)
 		}
+		config, err := munin.ParseConfig(valid)
 		if err != nil {
-			return nil, nil, err
-		}
-		if line == ".\n" { // munin end marker
-			break
-		}
-		if line[0] == '#' { // here it's just a comment, so ignore it
-			continue
-		}
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			return nil, nil, fmt.Errorf("Line unexpected: %s", line)
-		}
-		key, value := parts[0], strings.TrimRight(strings.Join(parts[1:], " "), "\n")
-
-		keyParts := strings.Split(key, ".")
-		if len(keyParts) > 1 { // it's a metric config (metric.label etc)
-			if _, ok := config[keyParts[0]]; !ok { //FIXME: is there no better way?
-				config[keyParts[0]] = make(map[string]string)
-			}
-			config[keyParts[0]][keyParts[1]] = value
-		} else {
-			graphConfig[keyParts[0]] = value
+			return nil, err
 		}
+		configs = append(configs, graphConfig{graph: section.Graph, config: config})
 	}
 	return
 }
 
 func registerMetrics() (err error) {
+	graphs, pendingPlugins = nil, nil // start over if an earlier attempt failed halfway
 	items, err := muninList()
 	if err != nil {
 		return
 	}
 
-	for _, name := range items {
+	nodeList, err := muninNodes()
+	if err != nil {
+		return
+	}
+	nodesMu.Lock()
+	nodes = nodeList
+	nodesMu.Unlock()
+
+	discovered = items
+	if err := registerGraphs(items); err != nil {
+		return err
+	}
+	updateNodeTags()
+	return nil
+}
+
+// registerGraphs registers the metrics of the plugins in names, skipping
+// disabled plugin families. Plugins whose config can't be read are left
+// pending.
+func registerGraphs(names []string) error {
+	var enabled []string
+	for _, name := range names {
+		family, _ := pluginFamilyOf(name)
+		if family != nil && !family.enabled {
+			log.Printf("Skipping %s, plugin family %s is disabled", name, family.name)
+			continue
+		}
+		enabled = append(enabled, name)
+	}
+	configs, err := muninConfigs(enabled)
+	if err != nil {
+		return err
+	}
+	for _, name := range enabled {
+		if _, ok := configs[name]; !ok {
+			pendingPlugins = append(pendingPlugins, name)
+			continue
+		}
+		registerGraph(name, configs[name])
+	}
+	return nil
+}
+
+func registerGraph(name string, configs []graphConfig) {
+	_, familyLabels := pluginFamilyOf(name)
+	extraLabels := pluginLabels(name)
+	for k, v := range familyLabels {
+		if _, ok := extraLabels[k]; !ok {
+			extraLabels[k] = v
+		}
+	}
+
+	registered := false
+	defer observePhase("registry", time.Now())
+	configFingerprints[name] = configFingerprint(configs)
+	for _, c := range configs {
+		if registerSection(name, c.graph, c.config, extraLabels) {
+			registered = true
+		}
+	}
+	if registered {
 		graphs = append(graphs, name)
-		configs, graphConfig, err := muninConfig(name)
-		if err != nil {
-			return err
+		if exportDirtyConfig(name, configs) {
+			dirtyFetched[name] = true
 		}
+	}
+}
 
-		for metric, config := range configs {
-			metricName := strings.Replace(name+"_"+metric, "-", "_", -1)
-			desc := graphConfig["graph_title"] + ": " + config["label"]
-			if config["info"] != "" {
-				desc = desc + ", " + config["info"]
+// registerSection registers the metrics of one graph of plugin. It returns
+// false if the graph is skipped.
+func registerSection(plugin, graph string, c *munin.Config, extraLabels map[string]string) bool {
+	configs, graphConfig := c.Fields, c.Graph
+	category := graphCategory(graphConfig)
+	if !collectorEnabled(category) {
+		log.Printf("Skipping %s, collector for category %s is disabled", graph, category)
+		return false
+	}
+	graphCategories[graph] = category
+	if _, ok := pluginCategories[plugin]; !ok {
+		pluginCategories[plugin] = category
+	}
+	graphVLabels[graph] = graphConfig["graph_vlabel"]
+	registerUnit(graph, graphConfig["graph_vlabel"])
+	graphHostNames[graph] = graphConfig["host_name"]
+	expectFields(plugin, graph, configs)
+	registerCDEFs(graph, configs)
+	extraLabels = withBaseLabel(extraLabels, graphConfig)
+
+	byPlugin := pluginNaming(plugin) == "plugin"
+	for metric, config := range configs {
+		// muninType can be empty and defaults to gauge
+		muninType := strings.ToLower(config["type"])
+		field := metric // the field the metric holds, none if shared by fields
+		if byPlugin {
+			nameByPlugin(graph, metric, muninType)
+			field = ""
+		}
+		metricName := metricNameFor(graph, metric)
+		desc := graphConfig["graph_title"] + ": " + config["label"]
+		if config["info"] != "" {
+			desc = desc + ", " + config["info"]
+		}
+		if byPlugin {
+			desc = graphConfig["graph_title"]
+		}
+		registerTransform(metricName, plugin, metric)
+		registerThresholds(metricName, plugin, graph, metric, config, extraLabels)
+		if deriveAsRate(muninType) {
+			registerRate(metricName, metric, config)
+		}
+		if muninType == "counter" {
+			registerCounter(metricName, metric, config)
+		}
+		if _, ok := gaugePerMetric[metricName]; ok {
+			continue // already registered by an earlier discovery or field
+		}
+		if _, ok := counterPerMetric[metricName]; ok {
+			continue
+		}
+		if exportedAsCounter(muninType) {
+			constLabels := prometheus.Labels{"type": muninType}
+			for k, v := range extraLabels {
+				constLabels[k] = v
 			}
-			muninType := strings.ToLower(config["type"])
-			// muninType can be empty and defaults to gauge
-			if muninType == "counter" || muninType == "derive" {
-				gv := prometheus.NewCounterVec(
-					prometheus.CounterOpts{
-						Name:        metricName,
-						Help:        desc,
-						ConstLabels: prometheus.Labels{"type": muninType},
-					},
-					[]string{"hostname", "graphname", "muninlabel"},
-				)
-				log.Printf("Registered counter %s: %s", metricName, desc)
-				counterPerMetric[metricName] = gv
-				prometheus.Register(gv)
-
-			} else {
-				gv := prometheus.NewGaugeVec(
-					prometheus.GaugeOpts{
-						Name:        metricName,
-						Help:        desc,
-						ConstLabels: prometheus.Labels{"type": "gauge"},
-					},
-					[]string{"hostname", "graphname", "muninlabel"},
-				)
-				log.Printf("Registered gauge %s: %s", metricName, desc)
-				gaugePerMetric[metricName] = gv
-				prometheus.Register(gv)
+			gv := prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name:        metricName,
+					Help:        desc,
+					ConstLabels: constLabels,
+				},
+				[]string{"hostname", "graphname", "muninlabel"},
+			)
+			log.Printf("Registered counter %s: %s", metricName, desc)
+			counterPerMetric[metricName] = gv
+			registry.Register(gv)
+			addCatalogEntry(metricName, "counter", desc, constLabels, plugin, field, graphConfig["graph_vlabel"])
+
+		} else {
+			constLabels := prometheus.Labels{"type": "gauge"}
+			if deriveAsRate(muninType) {
+				constLabels["type"] = muninType
+				desc += " (per second)"
 			}
+			for k, v := range extraLabels {
+				constLabels[k] = v
+			}
+			gv := prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name:        metricName,
+					Help:        desc,
+					ConstLabels: constLabels,
+				},
+				[]string{"hostname", "graphname", "muninlabel"},
+			)
+			log.Printf("Registered gauge %s: %s", metricName, desc)
+			gaugePerMetric[metricName] = gv
+			registry.Register(gv)
+			addCatalogEntry(metricName, "gauge", desc, constLabels, plugin, field, graphConfig["graph_vlabel"])
+			registerSmoothing(metricName, desc, constLabels, plugin, metric, graphConfig["graph_vlabel"])
 		}
 	}
-	return nil
+	return true
 }
 
-func fetchMetrics() (err error) {
-	for _, graph := range graphs {
-		munin, err := muninCommand("fetch " + graph)
-		if err != nil {
-			return err
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// metricNameFor returns the metric name of field in graph. Characters not
+// allowed in metric names, like the dots in multigraph names, become _.
+func metricNameFor(graph, field string) string {
+	if name, ok := familyNames[graph+"\xff"+field]; ok {
+		return name
+	}
+	return withUnitSuffix(graph, invalidMetricChars.ReplaceAllString(graph+"_"+field, "_"))
+}
+
+func fetchMetrics() error {
+	return fetchPlugins(context.Background(), nil)
+}
+
+// fetchPlugins fetches the registered plugins matching one of the globs in
+// patterns, or all of them if patterns is nil. Once ctx is done, no more
+// plugins are fetched, but those fetched already are still exported.
+func fetchPlugins(ctx context.Context, patterns []string) (err error) {
+	if patterns == nil || pluginStatus == nil {
+		pluginStatus = map[string]string{}
+	}
+	if spoolEnabled() {
+		return spoolfetchMetrics()
+	}
+	defer publishRollup()
+	now := time.Now()
+	var due []string
+	for _, plugin := range graphs {
+		if patterns != nil && !matchesAny(patterns, plugin) {
+			continue
 		}
+		if !pluginDue(plugin, now) {
+			pluginStatus[plugin] = "not due"
+			continue
+		}
+		if dirtyFetched[plugin] {
+			delete(dirtyFetched, plugin)
+			pluginStatus[plugin] = "ok"
+			continue
+		}
+		due = append(due, plugin)
+	}
 
-		for {
-			line, err := munin.ReadString('\n')
-			line = strings.TrimRight(line, "\n")
-			if err == io.EOF {
-				log.Fatalf("unexpected EOF, retrying")
-				return fetchMetrics()
+	fetched := fetchAll(ctx, due)
+	defer expireSeries(fetched)
+	var failed error // a failing plugin doesn't stop the others
+	for i, plugin := range due {
+		result, ok := fetched[plugin]
+		if !ok { // not fetched after munin-node became unreachable or ctx was done
+			if ctx.Err() != nil {
+				for _, skipped := range due[i:] {
+					pluginStatus[skipped] = "not fetched before the scrape timeout"
+				}
+				failed = fmt.Errorf("Scrape timeout reached, %d of %d plugins not fetched", len(due)-i, len(due))
 			}
-			if err != nil {
+			break
+		}
+		lines, err := result.lines, result.err
+		if err != nil {
+			if nodeUnreachable(err) && ctx.Err() == nil {
+				pluginStatus[plugin] = err.Error()
 				return err
 			}
-			if len(line) == 1 && line[0] == '.' {
-				log.Printf("End of list")
-				break
-			}
+			pluginFailed(plugin, err)
+			failed = err
+			continue
+		}
 
-			parts := strings.Fields(line)
-			if len(parts) != 2 {
-				log.Printf("unexpected line: %s", line)
-				continue
-			}
-			key, valueString := strings.Split(parts[0], ".")[0], parts[1]
-			value, err := strconv.ParseFloat(valueString, 64)
-			if err != nil {
-				log.Printf("Couldn't parse value in line %s, malformed?", line)
-				continue
-			}
-			name := strings.Replace(graph+"_"+key, "-", "_", -1)
-			log.Printf("%s: %f\n", name, value)
-			_, isGauge := gaugePerMetric[name]
-			if isGauge {
-				gaugePerMetric[name].WithLabelValues(hostname, graph, key).Set(value)
-				continue
-			}
-			_, isCounter := counterPerMetric[name]
-			if isCounter {
-				counterPerMetric[name].WithLabelValues(hostname, graph, key).Add(value)
+		if err := exportFetch(plugin, lines); err != nil {
+			pluginFailed(plugin, err)
+			failed = err
+			continue
+		}
+		pluginStatus[plugin] = "ok"
+	}
+	return failed
+}
+
+// exportFetch parses the fetch output lines of plugin and exports its
+// samples.
+func exportFetch(plugin string, lines []string) error {
+	start := time.Now()
+	var samples []Sample
+	var parseErr error
+	record := &fetchRecord{time: start}
+	fields := map[string]map[string]bool{} // by graph
+	graph := plugin
+	for _, line := range lines {
+		switch {
+		case parseErr != nil:
+			record.note(line, lineSkippedStrict)
+			continue
+		case strings.HasPrefix(line, "multigraph "):
+			graph = strings.TrimSpace(strings.TrimPrefix(line, "multigraph "))
+			record.note(line, lineMultigraph)
+			continue
+		case strings.HasPrefix(line, "#"):
+			record.note(line, lineComment)
+			continue
+		}
+		v, err := munin.ParseFetchLine(line)
+		if err != nil {
+			if isExtinfo(line) {
+				record.note(line, lineExtinfo)
 				continue
 			}
+			record.note(line, lineSkippedMalformed)
+			parseErr = malformed(plugin, "fetch", err)
+			continue
+		}
+		if fields[graph] == nil {
+			fields[graph] = map[string]bool{}
+		}
+		fields[graph][v.Field] = true
+		if v.Unknown && !exportUnknown(graph, v.Field) {
+			record.note(line, lineSkippedUnknown)
+			continue
+		}
+		record.note(line, lineParsed)
+		samples = append(samples, Sample{
+			Name:      metricNameFor(graph, v.Field),
+			Plugin:    plugin,
+			Graph:     graph,
+			Field:     v.Field,
+			Value:     v.Value,
+			Timestamp: v.Timestamp,
+		})
+	}
+	record.note(".", lineEndMarker)
+	keepLastFetch(plugin, record)
+	observePhase("parse", start)
+	if parseErr != nil {
+		return parseErr
+	}
+	checkDrift(plugin, fields)
+	zero := zeroSeries(samples)
+	samples = suppressZero(convertValues(samples, time.Now()), zero)
+
+	start = time.Now()
+	for _, sample := range samples {
+		for _, s := range applySampleHooks(sample) {
+			exportSample(s)
 		}
 	}
+	observePhase("registry", start)
+	log.Printf("End of list")
+	return nil
+}
+
+// fetchPlugin fetches the raw values of plugin. Nothing is exported until
+// the complete response has been read, so if the connection breaks
+// mid-response, the plugin is fetched once more over a new connection
+// without risking to count anything twice, and plugins fetched before
+// aren't touched again.
+func fetchPlugin(ctx context.Context, plugin string) (lines []string, err error) {
+	fetch := func(c *munin.Client) (err error) {
+		lines, err = c.Lines(ctx, "fetch "+plugin)
+		return
+	}
+	err = muninDo("fetch", fetch)
+	if broken(err) && !munin.IsTimeout(err) && ctx.Err() == nil {
+		log.Printf("Fetching %s failed mid-response, retrying it: %s", plugin, err)
+		err = muninDo("fetch", fetch)
+	}
 	return
 }
 
+// exportSample updates the metric of s with its value as converted by
+// convertValues, the increase for counters.
+func exportSample(s Sample) {
+	name, graph, key, value := s.Name, s.Graph, s.Field, s.Value
+	log.Printf("%s: %f\n", name, value)
+	if !s.Timestamp.IsZero() {
+		exportTimestamped(s)
+		return
+	}
+	touchSeries(s)
+	_, isGauge := gaugePerMetric[name]
+	if isGauge {
+		if math.IsNaN(value) { // unknown, neither smoothed nor summed up
+			for _, identity := range seriesIdentities(graph) {
+				gaugePerMetric[name].WithLabelValues(identity, graph, key).Set(value)
+			}
+			return
+		}
+		value = smooth(name, graph, key, value)
+		for _, identity := range seriesIdentities(graph) {
+			gaugePerMetric[name].WithLabelValues(identity, graph, key).Set(value)
+		}
+		rollupAdd(name, graph, key, value)
+		return
+	}
+	_, isCounter := counterPerMetric[name]
+	if isCounter {
+		if math.IsNaN(value) {
+			return // a counter can't be unknown, it keeps its value
+		}
+		for _, identity := range seriesIdentities(graph) {
+			counterPerMetric[name].WithLabelValues(identity, graph, key).Add(value)
+		}
+		return
+	}
+	if registerHookGauge(s) {
+		exportSample(s)
+	}
+}
+
+// deleteSeries removes the series of field in graph from the metric name
+// and forgets its counter state.
+func deleteSeries(name, graph, field string) {
+	hideSeries(name, graph, field)
+	delete(counterSamples, name+"\xff"+graph+"\xff"+field)
+}
+
+// hideSeries removes the series of field in graph from the metric name,
+// keeping its counter state.
+func hideSeries(name, graph, field string) {
+	for _, identity := range seriesIdentities(graph) {
+		if gv, ok := gaugePerMetric[name]; ok {
+			gv.DeleteLabelValues(identity, graph, field)
+		}
+		if cv, ok := counterPerMetric[name]; ok {
+			cv.DeleteLabelValues(identity, graph, field)
+		}
+	}
+	delete(rollupValues, name+"\xff"+graph+"\xff"+field)
+}
+
 func main() {
 	flag.Parse()
-	err := registerMetrics()
-	if err != nil {
-		log.Fatalf("Could not register metrics: %s", err)
+	if flag.Arg(0) == "loadtest" { // doesn't talk to munin at all
+		os.Exit(loadtest())
+	}
+	runStep("Loading configuration", exitConfig, loadConfig)
+	if flag.Arg(0) == "test" && flag.Arg(1) == "mappings" {
+		os.Exit(testMappings(flag.Args()[2:]))
+	}
+	switch flag.Arg(0) {
+	case "cardinality":
+		parseCardinalityArgs(flag.Args()[1:])
+	case "support-bundle":
+		parseSupportBundleArgs(flag.Args()[1:])
+	}
+	oneShot := *once || flag.Arg(0) == "verify" || flag.Arg(0) == "cardinality" || flag.Arg(0) == "support-bundle"
+	switch {
+	case oneShot:
+		metricsRegistered = runStep("Connecting to "+*muninAddress, exitConnect, connect) &&
+			runStep("Registering metrics", exitRegister, registerMetrics)
+	case !*muninLazyConnect:
+		// a node that's briefly unavailable doesn't stop the exporter
+		if err := ensureRegistered(); err != nil {
+			log.Printf("Registering metrics failed, retrying in the background: %s", err)
+		}
+		go retryRegistration()
 	}
 
-	go serveStatus()
+	if flag.Arg(0) == "verify" {
+		if !metricsRegistered {
+			os.Exit(exitRegister)
+		}
+		os.Exit(verify())
+	}
+	if flag.Arg(0) == "cardinality" {
+		if !metricsRegistered {
+			os.Exit(exitRegister)
+		}
+		os.Exit(cardinality())
+	}
+	if flag.Arg(0) == "support-bundle" {
+		os.Exit(supportBundle()) // also when munin-node couldn't be reached
+	}
+	if *once {
+		os.Exit(runOnce())
+	}
+
+	registerHandlers()
+	if *textfileDir == "" {
+		go runStep("Serving HTTP", exitServe, serveStatus)
+	}
+	go refreshConfig()
+	go handleShutdown()
+	go handleScrapeSignal()
+	go handleReload()
 
-	func() {
-		for {
-			log.Printf("Scraping")
-			err := fetchMetrics()
-			if err != nil {
-				log.Printf("Error occured when trying to fetch metrics: %s", err)
+	if *muninConnectionPerScrape {
+		muninPool.closeIdle()
+	}
+
+	if fetchOnScrape() && *textfileDir == "" {
+		if !*muninLazyConnect {
+			go warmUp()
+		}
+		select {} // scrapes register and fetch themselves
+	}
+	if !*muninLazyConnect {
+		<-registeredCh // by retryRegistration if not already
+	}
+	time.Sleep(scrapeJitter())
+	for {
+		// unless lazy, the metrics are registered already
+		if err := ensureRegistered(); err != nil {
+			log.Printf("Could not register metrics: %s", err)
+			time.Sleep(scrapeDelay(err))
+			continue
+		}
+		err := scrape()
+		time.Sleep(scrapeDelay(err))
+	}
+}
+
+// scrapeDelay returns how long to wait for the next scrape after one that
+// returned err. It holds scrapeMu, as reloads set the flags involved.
+func scrapeDelay(err error) time.Duration {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	return nextScrape(err) + scrapeJitter()
+}
+
+// scrape runs one scrape cycle.
+func scrape() error {
+	return scrapePlugins(context.Background(), nil)
+}
+
+// scrapePlugins runs a scrape cycle of the plugins matching patterns, or
+// of all plugins if patterns is nil, fetching until ctx is done.
+func scrapePlugins(ctx context.Context, patterns []string) error {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	if inMaintenance(time.Now()) {
+		log.Printf("Maintenance window active, skipping scrape")
+		return nil
+	}
+	if *muninConnectionPerScrape {
+		if err := connect(); err != nil {
+			log.Printf("Could not connect to %s: %s", *muninAddress, err)
+			if patterns == nil {
+				recordFullScrape(err)
 			}
-			time.Sleep(time.Duration(*muninScrapeInterval) * time.Second)
+			return err
+		}
+		defer muninPool.closeIdle()
+	}
+
+	if atomic.SwapInt32(&rediscoveryPending, 0) == 1 || rediscoveryDue(time.Now()) {
+		if err := rediscover(); err != nil {
+			log.Printf("Error occured when trying to rediscover plugins: %s", err)
+			atomic.StoreInt32(&rediscoveryPending, 1)
 		}
-	}()
+	}
+	if pluginConfigRefreshDue(time.Now()) {
+		if err := refreshPluginConfigs(); err != nil {
+			log.Printf("Error occured when trying to refresh plugin configs: %s", err)
+		}
+	}
+	log.Printf("Scraping")
+	start := time.Now()
+	err := fetchPlugins(ctx, patterns)
+	if err != nil {
+		log.Printf("Error occured when trying to fetch metrics: %s", err)
+	}
+	recordCycle(time.Since(start))
+	if patterns == nil {
+		recordFullScrape(err)
+		writeTextfile()
+	}
+	writeJournal(start, time.Since(start), err)
+	return err
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/pvdh/munin_exporter/muninmock"
+)
+
+// TestScrape registers and fetches the plugins of a muninmock node that
+// drops the connection every few commands, so registration and fetches
+// have to reconnect on the way.
+func TestScrape(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go muninmock.NewServer(&muninmock.Fixture{
+		Hostname: "mockhost",
+		Version:  "2.0.49",
+		Caps:     []string{"multigraph"},
+		Nodes:    []string{"mockhost"},
+		Plugins: map[string]muninmock.Plugin{
+			"load": {
+				Config: []string{"graph_title Load average", "graph_category system", "load.label load"},
+				Fetch:  [][]string{{"load.value 0.42"}, {"load.value U"}},
+			},
+			"net": {
+				Config: []string{"graph_title Traffic", "graph_category network", "rx.label rx", "rx.type DERIVE", "rx.min 0", "rx.cdef rx,8,*"},
+				Fetch:  [][]string{{"rx.value 1000"}, {"rx.value 3000"}},
+			},
+			"disk": {
+				Config: []string{
+					"multigraph disk_root", "graph_title Root", "graph_category disk", "used.label used",
+					"multigraph disk_home", "graph_title Home", "graph_category disk", "used.label used",
+				},
+				Fetch: [][]string{{"multigraph disk_root", "used.value 5", "multigraph disk_home", "used.value 7"}},
+			},
+		},
+		CloseAfter: 4,
+	}).Serve(l)
+	for name, value := range map[string]string{
+		"muninAddress":        l.Addr().String(),
+		"munin.retry.backoff": "1ms",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer muninPool.closeIdle()
+
+	if err := registerMetrics(); err != nil {
+		t.Fatalf("Registering metrics failed: %s", err)
+	}
+	if got, want := len(graphs), 3; got != want {
+		t.Fatalf("%d plugins registered, want %d: %v", got, want, graphs)
+	}
+	check := func(fetch int, want map[string]float64) {
+		t.Helper()
+		if err := fetchMetrics(); err != nil {
+			t.Fatalf("Fetch %d failed: %s", fetch, err)
+		}
+		values, err := exportedValues()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for series, v := range want {
+			got, ok := values[series]
+			if !ok || math.Abs(got-v) > 1e-6 {
+				t.Errorf("Fetch %d: %s = %v (exported: %v), want %v", fetch, series, got, ok, v)
+			}
+		}
+	}
+	check(1, map[string]float64{
+		"load.load":      0.42,
+		"disk_root.used": 5,
+		"disk_home.used": 7,
+		"net.rx":         0, // a counter with a cdef starts at 0
+	})
+	// the unknown load keeps its value, the counter goes up by the
+	// increase of 2000 times 8
+	check(2, map[string]float64{
+		"load.load":      0.42,
+		"disk_root.used": 5,
+		"net.rx":         16000,
+	})
+}
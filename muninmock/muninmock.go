@@ -0,0 +1,186 @@
+// Package muninmock implements a fake munin-node serving the responses
+// declared in a fixture, so munin clients can be tested without a munin
+// installation.
+//
+// A fixture is a JSON document like
+//
+//	{
+//	  "hostname": "testhost",
+//	  "version": "2.0.49",
+//	  "caps": ["multigraph", "dirtyconfig"],
+//	  "plugins": {
+//	    "load": {
+//	      "config": ["graph_title Load average", "load.label load"],
+//	      "fetch": [["load.value 0.42"], ["load.value 0.50"]]
+//	    }
+//	  }
+//	}
+//
+// Responses are given without the terminating "." line. Successive fetches
+// of a plugin walk through its fetch responses, repeating the last one.
+package muninmock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plugin declares the responses of a plugin.
+type Plugin struct {
+	Config []string   `json:"config"`
+	Fetch  [][]string `json:"fetch"`
+	// Fetcher, if set, generates the fetch responses instead of Fetch.
+	Fetcher func() []string `json:"-"`
+}
+
+// Fixture declares the behavior of a fake munin-node.
+type Fixture struct {
+	Hostname string            `json:"hostname"`
+	Version  string            `json:"version"`
+	Caps     []string          `json:"caps"`
+	Nodes    []string          `json:"nodes"`
+	Plugins  map[string]Plugin `json:"plugins"`
+	Spool    []string          `json:"spool"`
+	// CloseAfter makes the server drop every connection after that many
+	// commands, to exercise reconnection. 0 keeps connections open.
+	CloseAfter int `json:"close_after"`
+}
+
+// LoadFixture reads a JSON fixture from path.
+func LoadFixture(path string) (*Fixture, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixture{}
+	if err := json.Unmarshal(raw, f); err != nil {
+		return nil, fmt.Errorf("Couldn't parse fixture %s: %s", path, err)
+	}
+	if f.Hostname == "" {
+		f.Hostname = "localhost"
+	}
+	if len(f.Nodes) == 0 {
+		f.Nodes = []string{f.Hostname}
+	}
+	return f, nil
+}
+
+// Server serves a fixture over the munin-node protocol.
+type Server struct {
+	fixture *Fixture
+
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+// NewServer returns a server for f.
+func NewServer(f *Fixture) *Server {
+	return &Server{fixture: f, fetches: map[string]int{}}
+}
+
+// Serve accepts connections on l and serves each of them until l is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn runs a munin session on conn and closes it afterwards.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "# munin node at %s\n", s.fixture.Hostname)
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for commands := 1; scanner.Scan(); commands++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" {
+			return
+		}
+		s.respond(w, fields[0], fields[1:])
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if s.fixture.CloseAfter > 0 && commands >= s.fixture.CloseAfter {
+			return
+		}
+	}
+}
+
+func (s *Server) respond(w *bufio.Writer, cmd string, args []string) {
+	f := s.fixture
+	switch cmd {
+	case "cap":
+		fmt.Fprintf(w, "cap %s\n", strings.Join(f.Caps, " "))
+	case "version":
+		fmt.Fprintf(w, "munins node on %s version: %s\n", f.Hostname, f.Version)
+	case "list":
+		names := make([]string, 0, len(f.Plugins))
+		for name := range f.Plugins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "%s\n", strings.Join(names, " "))
+	case "nodes":
+		writeLines(w, f.Nodes)
+	case "config", "fetch":
+		name := strings.Join(args, " ")
+		plugin, ok := f.Plugins[name]
+		if !ok {
+			writeLines(w, []string{"# Unknown service"})
+			return
+		}
+		if cmd == "config" {
+			writeLines(w, plugin.Config)
+			return
+		}
+		writeLines(w, s.nextFetch(name, plugin))
+	case "spoolfetch":
+		writeLines(w, f.Spool)
+	default:
+		fmt.Fprintf(w, "# Unknown command. Try cap, list, nodes, config, fetch, version or quit\n")
+	}
+}
+
+// nextFetch returns the next fetch response of plugin.
+func (s *Server) nextFetch(name string, plugin Plugin) []string {
+	if plugin.Fetcher != nil {
+		return plugin.Fetcher()
+	}
+	if len(plugin.Fetch) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.fetches[name]
+	if i >= len(plugin.Fetch) {
+		i = len(plugin.Fetch) - 1
+	}
+	s.fetches[name] = i + 1
+	return plugin.Fetch[i]
+}
+
+// writeLines writes a multi-line response including the terminating ".".
+func writeLines(w *bufio.Writer, lines []string) {
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	fmt.Fprintf(w, ".\n")
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+)
+
+var muninNaming = flag.String("munin.naming", "field", "How metrics are named: field exports a metric per field named <graph>_<field>, plugin a metric per graph named <graph>, <graph>_total for counters, with the field in the muninlabel label. Override it per plugin with naming.<plugin glob> = field|plugin.")
+
+// familyNames holds the metric names of fields of graphs named by plugin,
+// keyed by graph and field.
+var familyNames = map[string]string{}
+
+// pluginNaming returns the naming mode of plugin, field or plugin, set by
+// the most specific matching glob.
+func pluginNaming(plugin string) string {
+	if mode, ok := mostSpecificMatch(settingsWithPrefix("naming."), plugin); ok {
+		return mode
+	}
+	return *muninNaming
+}
+
+// nameByPlugin makes the field of graph part of the metric shared by all
+// fields of the graph with the same kind of type.
+func nameByPlugin(graph, field, muninType string) {
+	name := withUnitSuffix(graph, invalidMetricChars.ReplaceAllString(graph, "_"))
+	if exportedAsCounter(muninType) {
+		name += "_total"
+	}
+	familyNames[graph+"\xff"+field] = name
+}
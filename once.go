@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+var once = flag.Bool("once", false, "Scrape all plugins once, write the metrics to stdout in the text exposition format and exit, with 0 if the scrape succeeded. Useful for cron jobs and debugging.")
+
+// runOnce runs one full scrape and writes the exposition to stdout. It
+// returns the exit code.
+func runOnce() int {
+	if !metricsRegistered {
+		return exitRegister
+	}
+	status := 0
+	if err := scrape(); err != nil {
+		status = 1
+	}
+	text, err := expositionText()
+	if err != nil {
+		log.Printf("Couldn't gather metrics: %s", err)
+		return 1
+	}
+	if _, err := os.Stdout.Write(text); err != nil {
+		log.Printf("Couldn't write metrics: %s", err)
+		return 1
+	}
+	return status
+}
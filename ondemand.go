@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	onDemand    = flag.Bool("on-demand", false, "Fetch from munin-node whenever the metrics are scraped instead of every -muninScrapeInterval, so the scrape interval is controlled by Prometheus.")
+	cacheMaxAge = flag.Duration("cache.max-age", 0, "Fetch from munin-node when the metrics are scraped, unless the values fetched last are younger than this. 0 disables it.")
+
+	scrapeTimeoutOffset = flag.Duration("web.timeout-offset", 500*time.Millisecond, "Stop fetching from munin-node this long before the timeout of a scrape that fetches, as sent by Prometheus in X-Prometheus-Scrape-Timeout-Seconds, and serve what was fetched so far.")
+)
+
+// fetchOnScrape reports whether scrapes fetch from munin-node, with
+// -on-demand or -cache.max-age, instead of a background loop.
+func fetchOnScrape() bool {
+	return *onDemand || *cacheMaxAge > 0
+}
+
+// onDemandMu allows one fetch at a time, concurrent scrapes wait for it.
+var onDemandMu sync.Mutex
+
+// onDemandCollector fetches the plugins matching patterns, or all plugins
+// if patterns is nil, from munin-node before every scrape, unless the last
+// values are younger than -cache.max-age, and reports on the last fetch.
+type onDemandCollector struct {
+	patterns []string
+	success  *prometheus.Desc
+	duration *prometheus.Desc
+	age      *prometheus.Desc
+
+	lastFetch    time.Time
+	lastSuccess  float64
+	lastDuration time.Duration
+}
+
+func newOnDemandCollector(patterns []string) *onDemandCollector {
+	return &onDemandCollector{
+		patterns: patterns,
+		success: prometheus.NewDesc(
+			"munin_on_demand_scrape_success",
+			"Whether the last fetch from munin-node triggered by a scrape succeeded.",
+			nil, nil,
+		),
+		duration: prometheus.NewDesc(
+			"munin_on_demand_scrape_duration_seconds",
+			"Time taken by the last fetch from munin-node triggered by a scrape.",
+			nil, nil,
+		),
+		age: prometheus.NewDesc(
+			"munin_cache_age_seconds",
+			"Age of the values served, fetched from munin-node by this or an earlier scrape.",
+			nil, nil,
+		),
+	}
+}
+
+func (oc *onDemandCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- oc.success
+	ch <- oc.duration
+	ch <- oc.age
+}
+
+// fetch fetches from munin-node until ctx is done, unless the values
+// fetched last are still fresh.
+func (oc *onDemandCollector) fetch(ctx context.Context) {
+	onDemandMu.Lock()
+	defer onDemandMu.Unlock()
+	if !oc.lastFetch.IsZero() && oc.lastSuccess == 1 && time.Since(oc.lastFetch) < *cacheMaxAge {
+		return
+	}
+	start := time.Now()
+	oc.lastSuccess = 1
+	if err := ensureRegistered(); err != nil {
+		log.Printf("Could not register metrics: %s", err)
+		oc.lastSuccess = 0
+	} else if err := scrapePlugins(ctx, oc.patterns); err != nil {
+		oc.lastSuccess = 0
+	}
+	oc.lastFetch, oc.lastDuration = start, time.Since(start)
+}
+
+func (oc *onDemandCollector) Collect(ch chan<- prometheus.Metric) {
+	onDemandMu.Lock()
+	defer onDemandMu.Unlock()
+	ch <- prometheus.MustNewConstMetric(oc.success, prometheus.GaugeValue, oc.lastSuccess)
+	ch <- prometheus.MustNewConstMetric(oc.duration, prometheus.GaugeValue, oc.lastDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(oc.age, prometheus.GaugeValue, time.Since(oc.lastFetch).Seconds())
+}
+
+// scrapeContext returns the context of a scrape, done a -web.timeout-offset
+// before the timeout Prometheus sent along, if any.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > *scrapeTimeoutOffset {
+		timeout -= *scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// onDemandHandler returns a handler serving the metrics gathered by g,
+// fetching the plugins matching patterns, or all plugins if patterns is
+// nil, first.
+func onDemandHandler(patterns []string, g prometheus.Gatherer) http.Handler {
+	oc := newOnDemandCollector(patterns)
+	fetcher := prometheus.NewRegistry()
+	fetcher.MustRegister(oc)
+	handler := handlerFor(prometheus.Gatherers{fetcher, g})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		oc.fetch(ctx)
+		if patterns == nil && (unavailableWhileWarmingUp(w) || unavailableAfterError(w)) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// metricsHandler returns the handler of the metrics path. When scrapes
+// fetch from munin-node, it does so before gathering the metrics, otherwise
+// it waits for the first scrape to complete.
+func metricsHandler() http.Handler {
+	if !fetchOnScrape() {
+		return ackSpool(warmUpGate(handlerFor(registry)))
+	}
+	return ackSpool(onDemandHandler(nil, registry))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var phaseDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "munin_scrape_phase_duration_seconds",
+		Help:    "Time spent in each phase of talking to munin-node: dial, banner, list, config, fetch, parse and registry (updating the exported metrics).",
+		Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+	},
+	[]string{"hostname", "phase"},
+)
+
+func init() {
+	registry.MustRegister(phaseDuration)
+}
+
+// observePhase records the time since start as spent in phase.
+func observePhase(phase string, start time.Time) {
+	phaseDuration.WithLabelValues(nodeHostname(), phase).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+var (
+	muninPipelineDepth           = flag.Int("munin.pipeline-depth", 0, "Number of config commands sent at once while registering plugins, saving a round trip each. 0 or 1 sends them one by one; only raise it for nodes that handle pipelined commands.")
+	muninRegistrationConcurrency = flag.Int("munin.registration-concurrency", 4, "Maximum number of config requests running at once while registering plugins, each on its own pooled connection. Clamped to -munin.pool.max-size, so with its default of 1, requests run one at a time.")
+	muninFetchConcurrency        = flag.Int("munin.fetch-concurrency", 1, "Maximum number of plugins fetched at once, each on its own pooled connection. Clamped to -munin.pool.max-size, so raise both to fetch in parallel.")
+)
+
+// fetchResult is the response to fetching a plugin.
+type fetchResult struct {
+	lines []string
+	err   error
+}
+
+// muninConfigs returns the configs of the plugins in names. Configs are
+// taken from the -munin.config-cache-dir cache if possible, the others are
+// requested from munin-node. Plugins whose config can't be read or parsed
+// are left out.
+func muninConfigs(names []string) (map[string][]graphConfig, error) {
+	responses := map[string][]string{}
+	missing := loadCachedConfigs(names, responses)
+	if err := fetchConfigs(missing, responses); err != nil {
+		return nil, err
+	}
+	for _, name := range missing {
+		if lines, ok := responses[name]; ok {
+			storeCachedConfig(name, lines)
+		}
+	}
+
+	configs := map[string][]graphConfig{}
+	for _, name := range names {
+		lines, ok := responses[name]
+		if !ok {
+			continue
+		}
+		c, err := parseGraphConfigs(name, lines)
+		if err != nil {
+			log.Printf("Not registering %s: %s", name, err)
+			continue
+		}
+		configs[name] = c
+	}
+	return configs, nil
+}
+
+// fetchConfigs requests the config of the plugins in names and stores the
+// raw responses in responses. Up to -munin.registration-concurrency
+// requests run at once, each on its own pooled connection. Plugins whose
+// config can't be read are left out; fetchConfigs only fails if none
+// could be read at all.
+func fetchConfigs(names []string, responses map[string][]string) error {
+	depth, concurrency := *muninPipelineDepth, *muninRegistrationConcurrency
+	if depth < 1 {
+		depth = 1
+	}
+	concurrency = poolBound(concurrency)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		lastErr error
+		slots   = make(chan struct{}, concurrency)
+	)
+	for len(names) > 0 {
+		batch := names
+		if len(batch) > depth {
+			batch = batch[:depth]
+		}
+		names = names[len(batch):]
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(batch []string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			got, err := fetchConfigBatch(batch)
+			mu.Lock()
+			defer mu.Unlock()
+			for name, lines := range got {
+				responses[name] = lines
+			}
+			if err != nil {
+				lastErr = err
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if len(responses) == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+// fetchConfigBatch requests the configs of batch, pipelined if it holds
+// more than one plugin. If the pipeline fails, the plugins are requested
+// one by one, so a single broken plugin doesn't take the others with it.
+func fetchConfigBatch(batch []string) (map[string][]string, error) {
+	got := map[string][]string{}
+	if len(batch) > 1 {
+		cmds := make([]string, len(batch))
+		for i, name := range batch {
+			cmds[i] = "config " + name
+		}
+		var responses [][]string
+		start := time.Now()
+		err := muninDo("config", func(c *munin.Client) (err error) {
+			responses, err = c.Pipeline(context.Background(), cmds...)
+			return
+		})
+		observePhase("config", start)
+		if err == nil {
+			for i, name := range batch {
+				got[name] = responses[i]
+			}
+			return got, nil
+		}
+		log.Printf("couldn't get pipelined configs for %v, requesting them one by one: %s", batch, err)
+	}
+
+	var lastErr error
+	for _, name := range batch {
+		lines, err := muninConfig(name)
+		if err != nil {
+			log.Printf("Not registering %s: %s", name, err)
+			lastErr = err
+			continue
+		}
+		got[name] = lines
+	}
+	return got, lastErr
+}
+
+// fetchAll fetches the plugins in names, up to -munin.fetch-concurrency at
+// once. Once munin-node is unreachable or ctx is done, no further fetches
+// are started.
+func fetchAll(ctx context.Context, names []string) map[string]fetchResult {
+	concurrency := poolBound(*muninFetchConcurrency)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]fetchResult, len(names))
+		failed  bool
+		slots   = make(chan struct{}, concurrency)
+	)
+	for _, name := range names {
+		slots <- struct{}{}
+		mu.Lock()
+		stop := failed || ctx.Err() != nil
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			lines, err := fetchPlugin(ctx, name)
+			observePhase("fetch", start)
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = fetchResult{lines: lines, err: err}
+			if nodeUnreachable(err) {
+				failed = true
+			}
+		}(name)
+	}
+	wg.Wait()
+	return results
+}
+
+// poolBound clamps concurrency to the connections the pool may open, and
+// to at least 1.
+func poolBound(concurrency int) int {
+	if concurrency > *muninPoolMaxSize {
+		concurrency = *muninPoolMaxSize
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return concurrency
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	muninConfigRefreshInterval = flag.Duration("munin.config-refresh-interval", 0, "Interval at which to re-read the config of every plugin and re-register the plugins whose config changed, e.g. 6h. 0 keeps the configs read at startup.")
+
+	configFingerprints = map[string]string{} // by plugin
+	lastConfigRefresh  time.Time
+)
+
+// configFingerprint summarizes the configs of a plugin, leaving out the
+// values sent along by dirtyconfig nodes.
+func configFingerprint(configs []graphConfig) string {
+	var b strings.Builder
+	for _, c := range configs {
+		fmt.Fprintf(&b, "multigraph %s\n", c.graph)
+		b.WriteString(sortedAttributes("", c.config.Graph))
+		fields := make([]string, 0, len(c.config.Fields))
+		for field := range c.config.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			b.WriteString(sortedAttributes(field+".", c.config.Fields[field]))
+		}
+	}
+	return b.String()
+}
+
+func sortedAttributes(prefix string, attributes map[string]string) string {
+	lines := make([]string, 0, len(attributes))
+	for key, value := range attributes {
+		if key != "value" {
+			lines = append(lines, prefix+key+" "+value+"\n")
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// pluginConfigRefreshDue reports whether -munin.config-refresh-interval
+// passed since the plugin configs were last read.
+func pluginConfigRefreshDue(now time.Time) bool {
+	if *muninConfigRefreshInterval <= 0 {
+		return false
+	}
+	if lastConfigRefresh.IsZero() { // read at startup
+		lastConfigRefresh = now
+	}
+	return now.Sub(lastConfigRefresh) >= *muninConfigRefreshInterval
+}
+
+// refreshPluginConfigs re-reads the config of every registered plugin from
+// munin-node, bypassing the config cache, and re-registers the plugins
+// whose config changed, e.g. got new fields or changed types.
+func refreshPluginConfigs() error {
+	lastConfigRefresh = time.Now()
+	names := append([]string(nil), graphs...)
+	responses := map[string][]string{}
+	if err := fetchConfigs(names, responses); err != nil {
+		return err
+	}
+	for _, name := range names {
+		lines, ok := responses[name]
+		if !ok {
+			continue
+		}
+		configs, err := parseGraphConfigs(name, lines)
+		if err != nil {
+			log.Printf("Keeping config of %s: %s", name, err)
+			continue
+		}
+		if configFingerprint(configs) == configFingerprints[name] {
+			continue
+		}
+		log.Printf("Config of %s changed, registering it again", name)
+		storeCachedConfig(name, lines)
+		kept := graphs[:0]
+		for _, graph := range graphs {
+			if graph != name {
+				kept = append(kept, graph)
+			}
+		}
+		graphs = kept
+		unregisterPlugin(name)
+		registerGraph(name, configs)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+var (
+	muninPoolMaxSize     = flag.Int("munin.pool.max-size", 1, "Maximum number of concurrent connections to munin-node.")
+	muninPoolIdleTimeout = flag.Duration("munin.pool.idle-timeout", 0, "Close connections idle for longer than this, 0 to keep them open.")
+	muninMaxConnAge      = flag.Duration("munin.max-conn-age", 0, "Reconnect to munin-node after a connection has been open this long, 0 to keep connections forever.")
+
+	muninPool = newClientPool()
+)
+
+type idleSession struct {
+	session *munin.Session
+	since   time.Time
+}
+
+// clientPool hands out munin sessions, keeping at most
+// -munin.pool.max-size of them, each with a connection of its own.
+// Callers wait for a session to be returned once the limit is reached.
+type clientPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	idle    []idleSession
+	open    int
+	waiting int
+	created map[*munin.Session]time.Time // when their connection was made
+	closing bool                         // no sessions are handed out anymore
+}
+
+// errPoolClosing is returned for sessions requested during shutdown.
+var errPoolClosing = errors.New("Shutting down")
+
+func newClientPool() *clientPool {
+	p := &clientPool{created: map[*munin.Session]time.Time{}}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// newSession returns a session that connects as configured by the munin.*
+// flags and records the capabilities of every new connection.
+func (p *clientPool) newSession() *munin.Session {
+	s := &munin.Session{
+		Caps: wantedCaps,
+		Dial: func(context.Context) (*munin.Client, error) { return connectClient() },
+	}
+	s.OnConnect = func(c *munin.Client, caps []string) {
+		recordCaps(caps)
+		p.mu.Lock()
+		p.created[s] = time.Now()
+		p.mu.Unlock()
+	}
+	return s
+}
+
+// get returns an idle session or opens a new one, connected either way.
+func (p *clientPool) get() (*munin.Session, error) {
+	p.mu.Lock()
+	var s *munin.Session
+	for s == nil {
+		if p.closing {
+			p.mu.Unlock()
+			return nil, errPoolClosing
+		}
+		p.expire()
+		if n := len(p.idle); n > 0 {
+			s = p.idle[n-1].session
+			p.idle = p.idle[:n-1]
+		} else if p.open < *muninPoolMaxSize {
+			s = p.newSession()
+			p.open++
+		} else {
+			p.waiting++
+			p.cond.Wait()
+			p.waiting--
+		}
+	}
+	p.mu.Unlock()
+
+	if err := s.Connect(context.Background()); err != nil {
+		p.mu.Lock()
+		delete(p.created, s)
+		p.open--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// release hands s back to the pool, closing its connection if err shows
+// it can't be used anymore.
+func (p *clientPool) release(s *munin.Session, err error) {
+	if broken(err) {
+		s.Close()
+	}
+	p.mu.Lock()
+	if broken(err) {
+		delete(p.created, s)
+	}
+	created, connected := p.created[s]
+	if connected && *muninMaxConnAge > 0 && time.Since(created) > *muninMaxConnAge {
+		p.mu.Unlock()
+		log.Printf("Connection open since %s reached its maximum age, closing it", created)
+		p.discard(s)
+		return
+	}
+	p.idle = append(p.idle, idleSession{session: s, since: time.Now()})
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *clientPool) discard(s *munin.Session) {
+	s.Close()
+	p.mu.Lock()
+	delete(p.created, s)
+	p.open--
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// closeIdle closes all connections not in use.
+func (p *clientPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.idle {
+		idle.session.Close()
+		delete(p.created, idle.session)
+		p.open--
+	}
+	p.idle = nil
+	p.cond.Broadcast()
+}
+
+// drain stops handing out connections, waits up to timeout for those in
+// use to be released and then says goodbye to munin-node on them.
+func (p *clientPool) drain(timeout time.Duration) {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		p.mu.Lock()
+		expired = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	p.mu.Lock()
+	p.closing = true
+	p.cond.Broadcast() // callers waiting for a connection give up
+	for p.open > len(p.idle) && !expired {
+		p.cond.Wait()
+	}
+	if inUse := p.open - len(p.idle); inUse > 0 {
+		log.Printf("%d munin connections still in use after %s, exiting anyway", inUse, timeout)
+	}
+	p.mu.Unlock()
+	p.quit()
+}
+
+// quit says goodbye to munin-node on all connections not in use.
+func (p *clientPool) quit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.idle {
+		ctx, cancel := context.WithTimeout(context.Background(), *muninWriteTimeout)
+		if err := idle.session.Quit(ctx); err != nil {
+			log.Printf("Couldn't quit munin session: %s", err)
+		}
+		cancel()
+		delete(p.created, idle.session)
+		p.open--
+	}
+	p.idle = nil
+}
+
+// expire closes connections idle for longer than the idle timeout or
+// older than the maximum connection age. The caller must hold p.mu.
+func (p *clientPool) expire() {
+	kept := p.idle[:0]
+	for _, idle := range p.idle {
+		created, connected := p.created[idle.session]
+		switch {
+		case *muninPoolIdleTimeout > 0 && time.Since(idle.since) > *muninPoolIdleTimeout:
+			log.Printf("Closing connection idle since %s", idle.since)
+		case connected && *muninMaxConnAge > 0 && time.Since(created) > *muninMaxConnAge:
+			log.Printf("Connection open since %s reached its maximum age, closing it", created)
+		default:
+			kept = append(kept, idle)
+			continue
+		}
+		idle.session.Close()
+		delete(p.created, idle.session)
+		p.open--
+	}
+	p.idle = kept
+}
+
+// usage returns the number of connections in use and of callers waiting
+// for one.
+func (p *clientPool) usage() (inUse, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open - len(p.idle), p.waiting
+}
+
+// broken reports whether err means the connection it occurred on is
+// unusable: closed, reset or desynchronized by a timeout.
+func broken(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+var muninProxyURL = flag.String("munin.proxy-url", "", "socks5:// or http:// proxy to dial munin-node through. Defaults to $ALL_PROXY, unless $NO_PROXY excludes munin-node.")
+
+// muninProxy returns the proxy URL to dial address through, or nil to dial
+// directly. -munin.proxy-url always applies, $ALL_PROXY only to addresses
+// $NO_PROXY doesn't exclude, and never to loopback addresses.
+func muninProxy(address string) (*url.URL, error) {
+	if *muninProxyURL != "" {
+		return url.Parse(*muninProxyURL)
+	}
+	config := httpproxy.Config{
+		HTTPProxy: getenvAny("ALL_PROXY", "all_proxy"),
+		NoProxy:   getenvAny("NO_PROXY", "no_proxy"),
+	}
+	return config.ProxyFunc()(&url.URL{Scheme: "http", Host: address})
+}
+
+// getenvAny returns the value of the first of names set in the environment.
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dialProxy dials address through a SOCKS5 or HTTP CONNECT proxy.
+func dialProxy(u *url.URL, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", u.Host, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.Dial(network, address)
+	case "http":
+		return dialHTTPConnect(u, dialer, address)
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+func dialHTTPConnect(u *url.URL, dialer *net.Dialer, address string) (net.Conn, error) {
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused CONNECT to %s: %s", u.Host, address, resp.Status)
+	}
+	// munin-node greets right away, so its banner may already be buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	recordFile = flag.String("record", "", "File to record the munin session to, for replaying it with -replay. Record with -munin.pool.max-size 1, so sessions don't interleave.")
+	replayFile = flag.String("replay", "", "Run against a session recorded with -record instead of munin-node.")
+
+	recorder     *sessionRecorder
+	recorderOnce sync.Once
+)
+
+// sessionRecorder writes the lines traced by munin clients to the record
+// file, in the "> command" and "< response" format read by -replay.
+type sessionRecorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *sessionRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Write(p)
+}
+
+// sessionTrace returns the writer munin clients trace to, or nil if neither
+// tracing nor recording is enabled.
+func sessionTrace() io.Writer {
+	var writers []io.Writer
+	if protocolTrace.enabled() {
+		writers = append(writers, protocolTrace)
+	}
+	if *recordFile != "" {
+		recorderOnce.Do(func() {
+			f, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				log.Printf("Couldn't open record file: %s", err)
+				return
+			}
+			recorder = &sessionRecorder{w: f}
+		})
+		if recorder != nil {
+			writers = append(writers, recorder)
+		}
+	}
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
+// multiLineCommands answer with several lines terminated by ".", all
+// others with a single line.
+var multiLineCommands = map[string]bool{
+	"config":     true,
+	"fetch":      true,
+	"nodes":      true,
+	"spoolfetch": true,
+}
+
+// recording is a munin session read from a -record file.
+type recording struct {
+	banner    string
+	responses map[string][][]string // by command, in the order recorded
+}
+
+var (
+	replayed     *recording
+	replayedErr  error
+	replayedOnce sync.Once
+)
+
+// replayKey returns the key responses to cmd are recorded under. The since
+// argument of spoolfetch changes from run to run, so it's left out.
+func replayKey(cmd string) string {
+	if strings.HasPrefix(cmd, "spoolfetch") {
+		return "spoolfetch"
+	}
+	return cmd
+}
+
+func commandName(cmd string) string {
+	return strings.SplitN(cmd, " ", 2)[0]
+}
+
+// readRecording parses a -record file. Responses are matched to commands in
+// order, which also works for pipelined commands.
+func readRecording(path string) (*recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rec := &recording{responses: map[string][][]string{}}
+	var pending []string // commands still waiting for (the rest of) their response
+	var current []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "> "):
+			pending = append(pending, strings.TrimPrefix(line, "> "))
+		case strings.HasPrefix(line, "< "):
+			line = strings.TrimPrefix(line, "< ")
+			if len(pending) == 0 {
+				if strings.HasPrefix(line, "# munin node at ") {
+					rec.banner = line
+				}
+				continue
+			}
+			cmd := pending[0]
+			current = append(current, line)
+			if multiLineCommands[commandName(cmd)] && line != "." {
+				continue
+			}
+			key := replayKey(cmd)
+			rec.responses[key] = append(rec.responses[key], current)
+			pending, current = pending[1:], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if rec.banner == "" {
+		return nil, fmt.Errorf("No munin banner in %s", path)
+	}
+	return rec, nil
+}
+
+// dialReplay returns a connection to a fake munin-node answering with the
+// responses of the -replay recording. Responses to a command are given in
+// the order they were recorded, repeating the last one once all have been
+// used.
+func dialReplay() (net.Conn, error) {
+	replayedOnce.Do(func() {
+		replayed, replayedErr = readRecording(*replayFile)
+	})
+	if replayedErr != nil {
+		return nil, replayedErr
+	}
+
+	client, server := net.Pipe()
+	go replayed.serve(server)
+	return client, nil
+}
+
+func (rec *recording) serve(conn net.Conn) {
+	defer conn.Close()
+	// Commands are read independently of writing the responses, so
+	// pipelined commands can't block net.Pipe's unbuffered writes.
+	cmds := make(chan string, 1024)
+	go func() {
+		defer close(cmds)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			cmds <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+
+	used := map[string]int{}
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s\n", rec.banner)
+	if err := w.Flush(); err != nil {
+		return
+	}
+	for cmd := range cmds {
+		if cmd == "quit" {
+			return
+		}
+		key := replayKey(cmd)
+		responses := rec.responses[key]
+		switch {
+		case len(responses) > 0:
+			i := used[key]
+			if i >= len(responses) {
+				i = len(responses) - 1
+			}
+			used[key] = i + 1
+			for _, line := range responses[i] {
+				fmt.Fprintf(w, "%s\n", line)
+			}
+		case multiLineCommands[commandName(cmd)]:
+			fmt.Fprintf(w, "# Unknown service\n.\n")
+		default:
+			fmt.Fprintf(w, "# Unknown command. Try cap, list, nodes, config, fetch, version or quit\n")
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+// pendingPlugins are the plugins whose config couldn't be read while
+// registering, to be registered by retryRegistration.
+var pendingPlugins []string
+
+// retryRegistration keeps registering the metrics, and then the plugins
+// whose config couldn't be read, in the background until it succeeds,
+// backing off between attempts like for commands. Meanwhile, the plugins
+// registered so far are served.
+func retryRegistration() {
+	backoff := munin.Backoff{Initial: *muninRetryBackoff, Max: *muninRetryMaxBackoff}
+	for attempt := 2; !registrationComplete(); attempt++ {
+		time.Sleep(backoff.Delay(attempt))
+		if err := ensureRegistered(); err != nil {
+			log.Printf("Registering metrics failed, retrying: %s", err)
+			continue
+		}
+		registerPending()
+	}
+}
+
+func registrationComplete() bool {
+	metricsRegisteredMu.Lock()
+	registered := metricsRegistered
+	metricsRegisteredMu.Unlock()
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	return registered && len(pendingPlugins) == 0
+}
+
+// registerPending registers the plugins whose config couldn't be read
+// before. Those still failing stay pending.
+func registerPending() {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	if len(pendingPlugins) == 0 {
+		return
+	}
+	pending := pendingPlugins
+	pendingPlugins = nil
+	log.Printf("Registering %d plugins whose config couldn't be read before", len(pending))
+	if err := registerGraphs(pending); err != nil {
+		log.Printf("Registering plugins failed, retrying: %s", err)
+		pendingPlugins = pending
+	}
+}
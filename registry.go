@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	webMaxRequests   = flag.Int("web.max-requests", 40, "Maximum number of scrapes of the metrics served at once, further ones are answered with 503. 0 means no limit.")
+	webErrorHandling = flag.String("web.error-handling", "http", "What to do when gathering the metrics fails: http answers with an HTTP error, continue serves the metrics that could be gathered.")
+	webOpenMetrics   = flag.Bool("web.enable-openmetrics", false, "Serve the OpenMetrics format to scrapers asking for it.")
+
+	// registry holds all metrics the exporter serves.
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// handlerFor returns a handler serving the metrics of g as configured by
+// the web.* flags, instrumented in the registry.
+func handlerFor(g prometheus.Gatherer) http.Handler {
+	opts := promhttp.HandlerOpts{
+		ErrorLog:            log.New(os.Stderr, "", log.LstdFlags),
+		ErrorHandling:       promhttp.HTTPErrorOnError,
+		MaxRequestsInFlight: *webMaxRequests,
+		EnableOpenMetrics:   *webOpenMetrics,
+	}
+	if *webErrorHandling == "continue" {
+		opts.ErrorHandling = promhttp.ContinueOnError
+	}
+	return promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(g, opts))
+}
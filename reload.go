@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleReload reloads the configuration on SIGHUP, without dropping the
+// HTTP listener.
+func handleReload() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Printf("Received SIGHUP, reloading")
+		if err := reload(); err != nil {
+			log.Printf("Reload failed: %s", err)
+		}
+	}
+}
+
+// reload re-reads the configuration file and rediscovers the plugins, so
+// changed -plugin.include and -plugin.exclude filters register or
+// unregister plugins. If the munin-node address changed, all plugins are
+// registered anew from the new node. Keys removed from the configuration
+// keep their previous value until the exporter is restarted.
+func reload() error {
+	update, err := readConfigUpdate(true) // secrets may have been rotated
+	if err != nil {
+		return err
+	}
+	return applyConfigUpdate(update)
+}
+
+// applyConfigUpdate applies a configuration read by readConfigUpdate
+// between scrapes and rediscovers the plugins, registering them anew if
+// the munin-node address changed.
+func applyConfigUpdate(update *configUpdate) error {
+	metricsRegisteredMu.Lock()
+	registered := metricsRegistered
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	address := *muninAddress
+	err := update.apply()
+	metricsRegisteredMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return nil // registration reads the new configuration
+	}
+
+	if *muninAddress != address {
+		log.Printf("munin-node address changed from %s to %s, registering its plugins", address, *muninAddress)
+		muninPool.closeIdle()
+		for _, plugin := range append(append([]string(nil), graphs...), pendingPlugins...) {
+			unregisterPlugin(plugin)
+		}
+		graphs, pendingPlugins, discovered = nil, nil, nil
+	}
+	refreshTransforms()
+	refreshThresholds()
+	return rediscover()
+}
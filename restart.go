@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var nodeRestarts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "munin_node_restarts_detected_total",
+		Help: "Number of times munin-node seemed to have been restarted, by the sign it was detected by: hostname or version.",
+	},
+	[]string{"hostname", "reason"},
+)
+
+func init() {
+	registry.MustRegister(nodeRestarts)
+}
+
+// nodeRestarted schedules a rediscovery, since plugins and their configs
+// often change when munin-node is restarted or upgraded.
+func nodeRestarted(reason string) {
+	log.Printf("munin-node seems to have been restarted (%s), rediscovering plugins", reason)
+	nodeRestarts.WithLabelValues(nodeHostname(), reason).Inc()
+	atomic.StoreInt32(&rediscoveryPending, 1)
+}
+
+// detectRestart compares the banner hostname and version of a new session
+// to those of the previous one. An empty version is unknown.
+func detectRestart(previousHostname, previousVersion, hostname, version string) {
+	switch {
+	case previousHostname != "" && previousHostname != hostname:
+		nodeRestarted("hostname")
+	case previousVersion != "" && version != "" && previousVersion != version:
+		nodeRestarted("version")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+var (
+	muninRetryAttempts   = flag.Int("munin.retry.max-attempts", 5, "Maximum number of attempts of a munin command when the connection is lost, including the first one.")
+	muninRetryBackoff    = flag.Duration("munin.retry.backoff", time.Second, "Delay before reconnecting after losing the connection, doubled on every further attempt.")
+	muninRetryMaxBackoff = flag.Duration("munin.retry.max-backoff", 30*time.Second, "Maximum delay between attempts of a munin command.")
+)
+
+// commandRetryPolicy returns the retry policy of cmd: the -munin.retry.*
+// flags, overridden by "retry.<command> = <attempts> [<backoff>]" settings,
+// e.g. "retry.fetch = 2 500ms".
+func commandRetryPolicy(cmd string) munin.Backoff {
+	p := munin.Backoff{
+		Attempts: *muninRetryAttempts,
+		Initial:  *muninRetryBackoff,
+		Max:      *muninRetryMaxBackoff,
+	}
+	value, ok := setting("retry." + cmd)
+	if !ok {
+		return p
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		log.Printf("Ignoring invalid retry policy for %s: %q", cmd, value)
+		return p
+	}
+	attempts, err := strconv.Atoi(fields[0])
+	if err != nil {
+		log.Printf("Ignoring invalid retry policy for %s: %s", cmd, err)
+		return p
+	}
+	p.Attempts = attempts
+	if len(fields) == 2 {
+		if p.Initial, err = time.ParseDuration(fields[1]); err != nil {
+			log.Printf("Ignoring invalid retry backoff for %s: %s", cmd, err)
+			p.Initial = *muninRetryBackoff
+		}
+	}
+	return p
+}
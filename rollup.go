@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rollupCategories = flag.Bool("rollup.categories", false, "Export per graph category sums of the last values of all GAUGE fields, and of the rates of DERIVE fields with -munin.derive rate, as munin_category_sum.")
+
+	categorySum = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_category_sum",
+			Help: "Sum of the last values of all GAUGE fields and rates in a graph category, grouped by vertical label. Percentages aren't summed up.",
+		},
+		[]string{"hostname", "category", "vlabel"},
+	)
+
+	// rollupValues holds the last value of every series summed up, so
+	// plugins that aren't due keep contributing theirs.
+	rollupValues = map[string]rollupValue{} // by series
+)
+
+func init() {
+	registry.MustRegister(categorySum)
+}
+
+type rollupValue struct {
+	category, vlabel string
+	value            float64
+}
+
+// rollupAdd records the value of the field of graph in the metric name,
+// a GAUGE field or a rate, e.g. of the bytes a network interface sends.
+// Fields are grouped by their graph's vertical label as well as the
+// category, so only values of the same unit are summed up. Percentages
+// can't be summed up meaningfully and are left out.
+func rollupAdd(name, graph, field string, value float64) {
+	if !*rollupCategories {
+		return
+	}
+	vlabel := graphVLabels[graph]
+	lower := strings.ToLower(vlabel)
+	if strings.Contains(lower, "%") || strings.Contains(lower, "percent") {
+		return
+	}
+	rollupValues[name+"\xff"+graph+"\xff"+field] = rollupValue{graphCategories[graph], vlabel, value}
+}
+
+// publishRollup exports the sums of the last values.
+func publishRollup() {
+	if !*rollupCategories {
+		return
+	}
+	type key struct{ category, vlabel string }
+	sums := map[key]float64{}
+	for _, v := range rollupValues {
+		sums[key{v.category, v.vlabel}] += v.value
+	}
+	categorySum.Reset()
+	for k, sum := range sums {
+		categorySum.WithLabelValues(nodeHostname(), k.category, k.vlabel).Set(sum)
+	}
+}
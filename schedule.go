@@ -0,0 +1,180 @@
+package main
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cronCache   = map[string]*cronSchedule{}
+	cronCacheMu sync.Mutex
+
+	// lastScheduledFetch holds when plugins with a schedule were fetched.
+	lastScheduledFetch = map[string]time.Time{}
+	// lastIntervalFetch holds when plugins with an interval were fetched.
+	lastIntervalFetch = map[string]time.Time{}
+
+	maintenanceActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_maintenance_active",
+			Help: "Whether a configured maintenance window is active, pausing all scrapes.",
+		},
+		[]string{"hostname", "window"},
+	)
+)
+
+func init() {
+	registry.MustRegister(maintenanceActive)
+}
+
+// cachedCron parses spec once, so time zones aren't loaded on every scrape.
+func cachedCron(spec string) (*cronSchedule, error) {
+	cronCacheMu.Lock()
+	defer cronCacheMu.Unlock()
+	if s, ok := cronCache[spec]; ok {
+		return s, nil
+	}
+	s, err := parseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	cronCache[spec] = s
+	return s, nil
+}
+
+// pluginDue reports whether plugin is to be fetched at now. Plugins
+// configured with "schedule.<plugin glob> = <cron>", e.g.
+// "schedule.apt = TZ=Europe/Berlin 0 3 * * *", are fetched once at startup
+// and then whenever their schedule fired since the last fetch. Plugins
+// without a schedule follow their interval, if any; all others are fetched
+// on every scrape. Of several matching globs, the most specific one's
+// schedule applies.
+func pluginDue(plugin string, now time.Time) bool {
+	spec, ok := mostSpecificMatch(settingsWithPrefix("schedule."), plugin)
+	if !ok {
+		return intervalDue(plugin, now)
+	}
+	s, err := cachedCron(spec)
+	if err != nil {
+		log.Printf("Ignoring invalid schedule for %s: %s", plugin, err)
+		return true
+	}
+	last, fetched := lastScheduledFetch[plugin]
+	if fetched && !s.firedBetween(last, now) {
+		return false
+	}
+	lastScheduledFetch[plugin] = now
+	return true
+}
+
+// intervalDue reports whether plugin is to be fetched at now according to
+// its interval. Plugins configured with "interval.<plugin> = <interval>",
+// e.g. "interval.smart_ = 900" or "interval.apt = 1h", are fetched only once
+// the interval has passed since their last fetch; their metrics keep the
+// last value in between.
+func intervalDue(plugin string, now time.Time) bool {
+	interval, ok := pluginInterval(plugin)
+	if !ok {
+		return true
+	}
+	if last, fetched := lastIntervalFetch[plugin]; fetched && now.Sub(last) < interval {
+		return false
+	}
+	lastIntervalFetch[plugin] = now
+	return true
+}
+
+// pluginInterval returns the interval configured for plugin with
+// "interval.<plugin>", or else for its graph category with
+// "category_interval.<category>", e.g. "category_interval.disk = 5m". Plugin
+// keys are plugin globs, family names, or the name of a wildcard plugin
+// ending in _ like smart_. Of several matching keys, the most specific one
+// wins, a family name ranking like the family's glob and a wildcard plugin
+// like its name followed by *. Intervals are given in seconds or as
+// durations.
+func pluginInterval(plugin string) (time.Duration, bool) {
+	family, _ := pluginFamilyOf(plugin)
+	intervals := settingsWithPrefix("interval.")
+	keys := make([]string, 0, len(intervals))
+	for key := range intervals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)              // of keys standing for the same glob, the first wins
+	matching := map[string]string{} // by the glob each key stands for
+	for _, key := range keys {
+		pattern := key
+		if matched, _ := path.Match(key, plugin); !matched {
+			switch {
+			case family != nil && key == family.name:
+				pattern = family.pattern
+			case strings.HasSuffix(key, "_") && strings.HasPrefix(plugin, key):
+				pattern = key + "*"
+			default:
+				continue
+			}
+		}
+		if _, ok := matching[pattern]; !ok {
+			matching[pattern] = intervals[key]
+		}
+	}
+	value, ok := mostSpecificMatch(matching, plugin)
+	if !ok {
+		category, ok := pluginCategories[plugin]
+		if !ok {
+			return 0, false
+		}
+		if value, ok = setting("category_interval." + category); !ok {
+			return 0, false
+		}
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Ignoring invalid interval for %s: %s", plugin, err)
+		return 0, false
+	}
+	return interval, true
+}
+
+// inMaintenance reports whether a maintenance window configured with
+// "maintenance.<name> = <cron> for <duration>", e.g.
+// "maintenance.patchday = TZ=Europe/Berlin 0 2 * * 0 for 2h", is active.
+func inMaintenance(now time.Time) bool {
+	active := false
+	maintenanceActive.Reset()
+	for name, value := range settingsWithPrefix("maintenance.") {
+		i := strings.LastIndex(value, " for ")
+		if i < 0 {
+			log.Printf("Ignoring maintenance window %s without duration", name)
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(value[i+len(" for "):]))
+		if err != nil {
+			log.Printf("Ignoring maintenance window %s: %s", name, err)
+			continue
+		}
+		s, err := cachedCron(value[:i])
+		if err != nil {
+			log.Printf("Ignoring maintenance window %s: %s", name, err)
+			continue
+		}
+
+		v := 0.0
+		if s.firedBetween(now.Add(-duration), now) {
+			active, v = true, 1
+		}
+		for _, identity := range identities() {
+			maintenanceActive.WithLabelValues(identity, name).Set(v)
+		}
+	}
+	return active
+}
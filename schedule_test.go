@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPluginInterval(t *testing.T) {
+	settingsMu.Lock()
+	settings = map[string]string{
+		"interval.if_*_eth*": "1",
+		"interval.if_eth0":   "2",
+		"interval.if":        "3",
+		"interval.smart_":    "4m",
+		"interval.smart_sd?": "5",
+	}
+	settingsMu.Unlock()
+	defer func() {
+		settingsMu.Lock()
+		settings = map[string]string{}
+		settingsMu.Unlock()
+	}()
+
+	for _, tt := range []struct {
+		plugin string
+		want   time.Duration
+	}{
+		{"if_eth0", 2 * time.Second}, // the literal beats the longer glob
+		{"if_eth1", 3 * time.Second}, // the family counts as if_*
+		{"if_err_eth1", time.Second},
+		{"smart_sda", 5 * time.Second},
+		{"smart_nvme0", 4 * time.Minute},
+	} {
+		for i := 0; i < 10; i++ { // map order mustn't matter
+			got, ok := pluginInterval(tt.plugin)
+			if !ok || got != tt.want {
+				t.Fatalf("pluginInterval(%s) = %s, %v, want %s", tt.plugin, got, ok, tt.want)
+			}
+		}
+	}
+}
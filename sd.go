@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// sdTargetGroup is a single entry of the Prometheus http_sd format.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// serveSD lists every munin node known to the exporter, including the
+// virtual nodes announced by the `nodes` command, so Prometheus can
+// discover them and scrape them individually.
+func serveSD(w http.ResponseWriter, r *http.Request) {
+	banner, _ := nodeBanner()
+	nodesMu.RLock()
+	groups := make([]sdTargetGroup, 0, len(nodes))
+	for _, node := range nodes {
+		groups = append(groups, sdTargetGroup{
+			Targets: []string{node},
+			Labels: map[string]string{
+				"__meta_munin_address":  muninTarget(),
+				"__meta_munin_hostname": banner,
+				"__meta_munin_node":     node,
+			},
+		})
+	}
+	nodesMu.RUnlock()
+	from, to, err := page(w, r, len(groups))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups = groups[from:to]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		log.Printf("Couldn't write sd response: %s", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var configDecryptCommand = flag.String("config.decrypt-command", "", "Command the configuration is piped through before it's parsed, e.g. \"sops --decrypt --input-type dotenv --output-type dotenv /dev/stdin\" or \"age --decrypt -i key.txt\", so it can be kept encrypted.")
+
+// secretRef matches references to secrets in configuration values:
+// ${NAME} for an environment variable and ${file:/path} for a file.
+var secretRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// decryptConfig pipes the raw configuration through -config.decrypt-command.
+func decryptConfig(raw []byte) ([]byte, error) {
+	args := strings.Fields(*configDecryptCommand)
+	if len(args) == 0 {
+		return raw, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stderr = bytes.NewReader(raw), &stderr
+	decrypted, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, fmt.Errorf("Decrypting %s failed: %s", *configFile, err)
+	}
+	return decrypted, nil
+}
+
+// expandSecrets replaces the secret references in values by the secrets.
+// Unresolvable references are errors, rather than silently empty secrets.
+func expandSecrets(values map[string]string) error {
+	for key, value := range values {
+		var err error
+		values[key] = secretRef.ReplaceAllStringFunc(value, func(ref string) string {
+			name := secretRef.FindStringSubmatch(ref)[1]
+			if strings.HasPrefix(name, "file:") {
+				secret, readErr := ioutil.ReadFile(strings.TrimPrefix(name, "file:"))
+				if readErr != nil {
+					err = fmt.Errorf("Couldn't read secret for %s: %s", key, readErr)
+				}
+				return strings.TrimRight(string(secret), "\r\n")
+			}
+			secret, ok := os.LookupEnv(name)
+			if !ok {
+				err = fmt.Errorf("Environment variable %s referenced by %s is not set", name, key)
+			}
+			return secret
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
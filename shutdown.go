@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var muninShutdownTimeout = flag.Duration("munin.shutdown-timeout", 10*time.Second, "Time to wait on shutdown for munin commands in progress to complete before their connections are closed regardless.")
+
+// handleShutdown ends the munin sessions cleanly on SIGINT or SIGTERM
+// instead of leaving munin-node to notice broken connections. Commands in
+// progress get up to -munin.shutdown-timeout to complete.
+func handleShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("Received %s, shutting down", sig)
+	muninPool.drain(*muninShutdownTimeout)
+	os.Exit(0)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rawPerMetric = map[string]*prometheus.GaugeVec{}
+	emaAlpha     = map[string]float64{}
+	emaValue     = map[string]float64{} // by series
+)
+
+// registerSmoothing enables exponential moving average smoothing for a
+// gauge configured with "smooth.<plugin glob>.<field glob> = <window>",
+// where window is the number of samples the average spans. The unsmoothed
+// value stays available as <metric>_raw.
+func registerSmoothing(metricName, desc string, constLabels prometheus.Labels, plugin, field, unit string) {
+	value, ok := pluginSetting("smooth", plugin, field)
+	if !ok {
+		return
+	}
+	window, err := strconv.Atoi(value)
+	if err != nil || window < 1 {
+		log.Printf("Ignoring invalid smoothing window %q for %s", value, metricName)
+		return
+	}
+
+	raw := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        metricName + "_raw",
+			Help:        desc + " (unsmoothed)",
+			ConstLabels: constLabels,
+		},
+		[]string{"hostname", "graphname", "muninlabel"},
+	)
+	if err := registry.Register(raw); err != nil {
+		log.Printf("Couldn't register %s_raw: %s", metricName, err)
+		return
+	}
+	log.Printf("Smoothing %s over %d samples", metricName, window)
+	rawPerMetric[metricName] = raw
+	emaAlpha[metricName] = 2 / float64(window+1)
+	addCatalogEntry(metricName+"_raw", "gauge", desc+" (unsmoothed)", constLabels, plugin, field, unit)
+}
+
+// smooth records the raw value of a smoothed gauge and returns the updated
+// moving average. Values of other gauges are returned unchanged.
+func smooth(name, graph, field string, value float64) float64 {
+	alpha, ok := emaAlpha[name]
+	if !ok {
+		return value
+	}
+	for _, identity := range seriesIdentities(graph) {
+		rawPerMetric[name].WithLabelValues(identity, graph, field).Set(value)
+	}
+
+	key := name + "\xff" + graph + "\xff" + field
+	previous, seen := emaValue[key]
+	if seen {
+		value = alpha*value + (1-alpha)*previous
+	}
+	emaValue[key] = value
+	return value
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+var (
+	muninSpoolfetch = flag.Bool("munin.spoolfetch", false, "Read the values spooled by munin-async with spoolfetch instead of fetching every plugin, and expose them with their original timestamps.")
+	muninSpoolQueue = flag.Int("munin.spoolfetch.max-queue", 1000, "Maximum number of spooled samples kept per series until Prometheus collects them.")
+	muninSpoolAge   = flag.Duration("munin.spoolfetch.max-age", time.Hour, "Maximum age of spooled samples kept until Prometheus collects them, older ones are dropped. 0 keeps them regardless of their age.")
+)
+
+// spooled is a sample read by spoolfetch.
+type spooled struct {
+	timestamp time.Time
+	value     float64
+	queued    time.Time // when it was added to the queue
+}
+
+// spoolSeries holds the samples of one series not yet collected.
+type spoolSeries struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	graph     string
+	field     string
+	pending   []spooled
+	last      spooled
+}
+
+// spoolCollector exposes samples with the timestamps munin sent along,
+// whether spooled by munin-async or fetched as "field.value <epoch>:<value>".
+// A series can only carry one sample per scrape, so collecting hands out
+// the oldest pending sample of each series, which stays pending until a
+// scrape of the metrics path acknowledges it or it expires. Gathering the
+// metrics for anything else doesn't use samples up. As long as Prometheus
+// scrapes more often than munin-async samples, nothing is lost. Once a
+// series has no pending samples left, its last sample is repeated.
+type spoolCollector struct {
+	mu     sync.Mutex
+	series map[string]*spoolSeries
+	since  time.Time
+}
+
+var spool = &spoolCollector{series: map[string]*spoolSeries{}}
+
+func init() {
+	// spool describes nothing up front: its series share their names with
+	// the metrics registered from the plugin configs, which stay empty
+	// while spoolfetch is used.
+	registry.MustRegister(spool)
+}
+
+// spoolEnabled reports whether values are read with spoolfetch.
+func spoolEnabled() bool {
+	return *muninSpoolfetch && hasCap("spool")
+}
+
+func (sc *spoolCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (sc *spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range sc.series {
+		sample := s.last
+		if len(s.pending) > 0 {
+			sample = s.pending[0]
+		}
+		for _, identity := range seriesIdentities(s.graph) {
+			m, err := prometheus.NewConstMetric(s.desc, s.valueType, sample.value, identity, s.graph, s.field)
+			if err != nil {
+				log.Printf("Couldn't expose spooled sample: %s", err)
+				continue
+			}
+			ch <- prometheus.NewMetricWithTimestamp(sample.timestamp, m)
+		}
+	}
+}
+
+// ack acknowledges the samples handed out by a scrape that started at
+// start: the oldest pending sample of each series, if it was queued before
+// then, becomes the series' last sample.
+func (sc *spoolCollector) ack(start time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range sc.series {
+		if len(s.pending) > 0 && s.pending[0].queued.Before(start) {
+			s.last, s.pending = s.pending[0], s.pending[1:]
+		}
+		s.expire()
+	}
+}
+
+// expire drops the pending samples older than -munin.spoolfetch.max-age.
+func (s *spoolSeries) expire() {
+	if *muninSpoolAge <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-*muninSpoolAge)
+	for len(s.pending) > 0 && s.pending[0].timestamp.Before(cutoff) {
+		s.last, s.pending = s.pending[0], s.pending[1:]
+	}
+}
+
+// usage returns the number of series and the length of the longest queue.
+func (sc *spoolCollector) usage() (series, longest int) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range sc.series {
+		if len(s.pending) > longest {
+			longest = len(s.pending)
+		}
+	}
+	return len(sc.series), longest
+}
+
+// add queues a spooled sample of the metric described by entry.
+func (sc *spoolCollector) add(entry catalogEntry, graph, field string, sample spooled) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	key := entry.Name + "\xff" + graph + "\xff" + field
+	s, ok := sc.series[key]
+	if !ok {
+		valueType := prometheus.GaugeValue
+		if entry.Type == "counter" {
+			valueType = prometheus.CounterValue
+		}
+		s = &spoolSeries{
+			desc:      prometheus.NewDesc(entry.Name, entry.Help, entry.Labels, entry.ConstLabels),
+			valueType: valueType,
+			graph:     graph,
+			field:     field,
+		}
+		sc.series[key] = s
+	}
+	if n := len(s.pending); n > 0 && !sample.timestamp.After(s.pending[n-1].timestamp) {
+		return // already queued
+	}
+	s.expire()
+	sample.queued = time.Now()
+	if len(s.pending) >= *muninSpoolQueue {
+		log.Printf("Spool queue of %s full, dropping oldest sample", entry.Name)
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, sample)
+}
+
+// exportTimestamped queues s for exposition with its timestamp. The series
+// is removed from the regular metrics, which can't carry timestamps, so it
+// isn't exposed twice. Counters are exposed with munin's absolute values,
+// converted by convertValues like every other value.
+func exportTimestamped(s Sample) {
+	catalogMu.RLock()
+	entry, ok := catalog[s.Name]
+	catalogMu.RUnlock()
+	if !ok {
+		return
+	}
+	deleteSeries(s.Name, s.Graph, s.Field)
+	spool.add(entry, s.Graph, s.Field, spooled{timestamp: s.Timestamp, value: s.Value})
+}
+
+// spoolfetchMetrics reads everything munin-async spooled since the last
+// call and queues it for collection.
+func spoolfetchMetrics() error {
+	if spool.since.IsZero() {
+		spool.since = time.Now().Add(-time.Duration(*muninScrapeInterval) * time.Second)
+	}
+	var lines []string
+	start := time.Now()
+	err := muninDo("spoolfetch", func(c *munin.Client) (err error) {
+		lines, err = c.Spoolfetch(context.Background(), spool.since)
+		return
+	})
+	observePhase("fetch", start)
+	if err != nil {
+		return err
+	}
+
+	newest := spool.since
+	var batch []Sample // the samples of one graph spooled at the same time
+	flush := func() {
+		for _, sample := range convertValues(batch, time.Now()) {
+			for _, s := range applySampleHooks(sample) {
+				exportTimestamped(s)
+			}
+		}
+		batch = nil
+	}
+	for _, section := range munin.SplitMultigraph("", lines) {
+		graph := section.Graph
+		for _, line := range section.Lines {
+			v, err := munin.ParseFetchLine(line)
+			if err != nil || v.Timestamp.IsZero() {
+				continue // config lines are spooled as well
+			}
+			if v.Unknown {
+				continue
+			}
+			if v.Timestamp.After(newest) {
+				newest = v.Timestamp
+			}
+			sample := Sample{
+				Name:      metricNameFor(graph, v.Field),
+				Graph:     graph,
+				Field:     v.Field,
+				Value:     v.Value,
+				Timestamp: v.Timestamp,
+			}
+			catalogMu.RLock()
+			sample.Plugin = catalog[sample.Name].Plugin
+			catalogMu.RUnlock()
+			if len(batch) > 0 && !batch[0].Timestamp.Equal(sample.Timestamp) {
+				flush()
+			}
+			batch = append(batch, sample)
+		}
+		flush()
+	}
+	spool.since = newest
+	return nil
+}
+
+// ackSpool wraps the handler of the metrics path, acknowledging the spooled
+// samples it handed out once it answered successfully.
+func ackSpool(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		if sw.status == http.StatusOK {
+			spool.ack(start)
+		}
+	})
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	muninSSHHost       = flag.String("munin.ssh-host", "", "host:port of an SSH jump host to reach munin-node through. Disabled if empty.")
+	muninSSHUser       = flag.String("munin.ssh-user", os.Getenv("USER"), "User to log into the SSH jump host as.")
+	muninSSHKey        = flag.String("munin.ssh-key", os.Getenv("HOME")+"/.ssh/id_rsa", "Private key to authenticate to the SSH jump host with.")
+	muninSSHKnownHosts = flag.String("munin.ssh-known-hosts", os.Getenv("HOME")+"/.ssh/known_hosts", "known_hosts file to verify the SSH jump host against. Empty to skip verification.")
+
+	sshTunnel   *ssh.Client
+	sshTunnelMu sync.Mutex
+)
+
+// dialSSH dials munin-node through the SSH jump host. The SSH connection is
+// kept open for later dials and transparently re-established if it died.
+// The jump host is connected to with dialer. Both connecting to it and
+// dialing through it are bounded by -munin.connect-timeout.
+func dialSSH(dialer *net.Dialer, network, address string) (net.Conn, error) {
+	sshTunnelMu.Lock()
+	defer sshTunnelMu.Unlock()
+
+	ctx := context.Background()
+	if *muninConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *muninConnectTimeout)
+		defer cancel()
+	}
+	if sshTunnel != nil {
+		conn, err := sshTunnel.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		log.Printf("SSH tunnel to %s broken, reconnecting: %s", *muninSSHHost, err)
+		sshTunnel.Close()
+		sshTunnel = nil
+	}
+
+	tunnel, err := sshConnect(dialer)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't connect to SSH jump host %s: %s", *muninSSHHost, err)
+	}
+	sshTunnel = tunnel
+	return sshTunnel.DialContext(ctx, network, address)
+}
+
+func sshConnect(dialer *net.Dialer) (*ssh.Client, error) {
+	key, err := ioutil.ReadFile(*muninSSHKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if *muninSSHKnownHosts != "" {
+		hostKeyCallback, err = knownhosts.New(*muninSSHKnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config := &ssh.ClientConfig{
+		User:            *muninSSHUser,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}
+	log.Printf("Connecting to SSH jump host %s", *muninSSHHost)
+	conn, err := dialer.Dial("tcp", *muninSSHHost)
+	if err != nil {
+		return nil, err
+	}
+	if *muninConnectTimeout > 0 { // covers the handshake as well
+		conn.SetDeadline(time.Now().Add(*muninConnectTimeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, *muninSSHHost, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	strict = flag.Bool("strict", false, "Fail plugins sending malformed config or fetch lines instead of skipping those lines.")
+
+	malformedLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "munin_plugin_malformed_lines_total",
+			Help: "Number of lines munin plugins sent that couldn't be parsed, by command.",
+		},
+		[]string{"hostname", "plugin", "command"},
+	)
+)
+
+func init() {
+	registry.MustRegister(malformedLines)
+}
+
+// malformed records a malformed line in the response of plugin to command.
+// In -strict mode, it returns the error failing the plugin.
+func malformed(plugin, command string, err error) error {
+	malformedLines.WithLabelValues(nodeHostname(), plugin, command).Inc()
+	if *strict {
+		return fmt.Errorf("Malformed %s response of %s: %s", command, plugin, err)
+	}
+	log.Printf("Skipping malformed %s line of %s: %s", command, plugin, err)
+	return nil
+}
+
+// isExtinfo reports whether line is a "field.extinfo <text>" line, which
+// plugins may send along with their values.
+func isExtinfo(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && strings.HasSuffix(fields[0], ".extinfo")
+}
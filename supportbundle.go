@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	supportBundleOutput string
+	supportBundleLog    bytes.Buffer
+)
+
+// parseSupportBundleArgs applies the flags of the support-bundle
+// subcommand: --target overrides -muninAddress and --output names the
+// tarball. The session with munin-node is traced and the log kept, so both
+// end up in the bundle, and failing to connect doesn't exit.
+func parseSupportBundleArgs(args []string) {
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	target := fs.String("target", "", "munin-node address to collect a bundle for, overriding -muninAddress.")
+	fs.StringVar(&supportBundleOutput, "output", "", "Tarball to write, munin-support-<target>-<time>.tar.gz by default.")
+	fs.Parse(args)
+	if *target != "" {
+		*muninAddress = *target
+	}
+	if supportBundleOutput == "" {
+		name := strings.NewReplacer(":", "_", "/", "_").Replace(*muninAddress)
+		supportBundleOutput = fmt.Sprintf("munin-support-%s-%s.tar.gz", name, time.Now().Format("20060102T150405"))
+	}
+	if *muninTraceBuffer <= 0 {
+		*muninTraceBuffer = 4 << 20
+	}
+	*fatalErrorPolicy = "degrade" // write the bundle even if munin-node can't be reached
+	log.SetOutput(io.MultiWriter(os.Stderr, &supportBundleLog))
+}
+
+// supportState is the internal state of the exporter included in a
+// support bundle.
+type supportState struct {
+	Address      string            `json:"address"`
+	Hostname     string            `json:"hostname"`
+	Version      string            `json:"version"`
+	Capabilities []string          `json:"capabilities"`
+	Discovered   []string          `json:"discovered"`
+	Registered   []string          `json:"registered"`
+	Status       map[string]string `json:"status"`
+	FetchError   string            `json:"fetch_error,omitempty"`
+	Catalog      []catalogEntry    `json:"catalog"`
+}
+
+// supportBundle scrapes the target once, if it could be registered, and
+// writes the effective configuration, the protocol trace, the log, the
+// exposition, the last fetches and the internal state to a tarball. It's
+// written even if munin-node can't be reached, since that's when it's
+// needed most. It returns the exit code.
+func supportBundle() int {
+	state := supportState{Address: *muninAddress}
+	if metricsRegistered {
+		if err := fetchMetrics(); err != nil {
+			log.Printf("Error occured when trying to fetch metrics: %s", err)
+			state.FetchError = err.Error()
+		}
+	}
+	state.Hostname, state.Version = nodeBanner()
+	state.Discovered, state.Registered, state.Status = discovered, graphs, pluginStatus
+	nodeCapsMu.RLock()
+	for capability := range nodeCaps {
+		state.Capabilities = append(state.Capabilities, capability)
+	}
+	nodeCapsMu.RUnlock()
+	sort.Strings(state.Capabilities)
+	catalogMu.RLock()
+	for _, entry := range catalog {
+		state.Catalog = append(state.Catalog, entry)
+	}
+	catalogMu.RUnlock()
+	sort.Slice(state.Catalog, func(i, j int) bool { return state.Catalog[i].Name < state.Catalog[j].Name })
+
+	files := map[string][]byte{
+		"config.txt":    effectiveConfig(),
+		"lastfetch.txt": lastFetchesText(),
+	}
+	protocolTrace.mu.Lock()
+	files["trace.txt"] = []byte(strings.Join(protocolTrace.lines, ""))
+	protocolTrace.mu.Unlock()
+	var err error
+	if files["state.json"], err = json.MarshalIndent(state, "", "  "); err != nil {
+		log.Printf("Couldn't encode state: %s", err)
+		return 1
+	}
+	if files["metrics.txt"], err = expositionText(); err != nil {
+		log.Printf("Couldn't gather metrics: %s", err)
+	}
+	files["log.txt"] = supportBundleLog.Bytes()
+
+	if err := writeTarball(supportBundleOutput, files); err != nil {
+		log.Printf("Couldn't write support bundle: %s", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", supportBundleOutput)
+	return 0
+}
+
+// effectiveConfig lists the value of every flag and setting, with
+// credentials redacted.
+func effectiveConfig() []byte {
+	var b bytes.Buffer
+	b.WriteString("# flags\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&b, "%s = %s\n", f.Name, redact(f.Name, f.Value.String()))
+	})
+	b.WriteString("\n# settings\n")
+	settingsMu.RLock()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", key, redact(key, settings[key]))
+	}
+	settingsMu.RUnlock()
+	return b.Bytes()
+}
+
+// redact hides the value of key if it looks like a credential, and the
+// password of URLs.
+func redact(key, value string) string {
+	lower := strings.ToLower(key)
+	for _, word := range []string{"password", "secret", "token"} {
+		if strings.Contains(lower, word) && !strings.HasSuffix(lower, "-file") {
+			return "<redacted>"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+func lastFetchesText() []byte {
+	var b bytes.Buffer
+	lastFetchesMu.RLock()
+	defer lastFetchesMu.RUnlock()
+	plugins := make([]string, 0, len(lastFetches))
+	for plugin := range lastFetches {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+	for _, plugin := range plugins {
+		writeFetchRecord(&b, plugin, lastFetches[plugin])
+	}
+	return b.Bytes()
+}
+
+// writeTarball writes files to a gzipped tarball at path, in a directory
+// named after it.
+func writeTarball(path string, files map[string][]byte) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	dir := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		header := &tar.Header{Name: dir + "/" + name, Mode: 0600, Size: int64(len(files[name])), ModTime: now}
+		if err = tw.WriteHeader(header); err != nil {
+			return
+		}
+		if _, err = tw.Write(files[name]); err != nil {
+			return
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	return gz.Close()
+}
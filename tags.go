@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// categoryTags maps graph categories to the node tags they imply. Further
+// mappings are configured with "tag.<category> = <tag>"; an empty tag
+// disables a default mapping.
+var categoryTags = map[string]string{
+	"db":             "db",
+	"postgresql":     "db",
+	"mysql":          "db",
+	"webserver":      "web",
+	"appserver":      "app",
+	"mail":           "mail",
+	"spamfilter":     "mail",
+	"dns":            "dns",
+	"loadbalancer":   "lb",
+	"virtualization": "hypervisor",
+	"cloud":          "cloud",
+	"fw":             "firewall",
+	"backup":         "backup",
+	"search":         "search",
+	"streaming":      "streaming",
+}
+
+var nodeTags = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "munin_node_tag_info",
+		Help: "Tags derived from the graph categories a node exposes, 1 for every tag of the node.",
+	},
+	[]string{"hostname", "tag"},
+)
+
+func init() {
+	registry.MustRegister(nodeTags)
+}
+
+// updateNodeTags derives the node's tags from the categories of its
+// registered graphs.
+func updateNodeTags() {
+	tags := map[string]bool{}
+	for _, category := range graphCategories {
+		tag, ok := categoryTags[category]
+		if configured, found := setting("tag." + category); found {
+			tag, ok = configured, true
+		}
+		if ok && tag != "" {
+			tags[strings.TrimSpace(tag)] = true
+		}
+	}
+
+	nodeTags.Reset()
+	for tag := range tags {
+		for _, identity := range identities() {
+			nodeTags.WithLabelValues(identity, tag).Set(1)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+var textfileDir = flag.String("output.textfile-dir", "", "Directory of node_exporter's textfile collector to write the metrics to after every scrape, instead of serving them over HTTP. Samples with timestamps, as read by spoolfetch, are left out. Disabled if empty.")
+
+// textfilePrefixes are the metrics node_exporter exposes itself, left out
+// of the textfile so they don't collide.
+var textfilePrefixes = []string{"go_", "process_", "promhttp_"}
+
+// textfileWritten is the path of the textfile written last, removed once
+// the hostname changes.
+var textfileWritten string
+
+// writeTextfile writes the metrics to munin_<hostname>.prom in
+// -output.textfile-dir. The file is replaced atomically, so node_exporter
+// never reads a partial file. Samples with timestamps are left out, the
+// textfile collector rejects them.
+func writeTextfile() {
+	if *textfileDir == "" {
+		return
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		log.Printf("Couldn't gather metrics for the textfile: %s", err)
+		return
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if hasAnyPrefix(family.GetName(), textfilePrefixes) {
+			continue
+		}
+		metrics := family.Metric[:0]
+		for _, m := range family.Metric {
+			if m.TimestampMs == nil {
+				metrics = append(metrics, m)
+			}
+		}
+		if family.Metric = metrics; len(metrics) == 0 {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			log.Printf("Couldn't format metrics for the textfile: %s", err)
+			return
+		}
+	}
+
+	banner, _ := nodeBanner()
+	name := "munin_" + invalidMetricChars.ReplaceAllString(banner, "_") + ".prom"
+	path := filepath.Join(*textfileDir, name)
+	// node_exporter only reads *.prom files, so it skips the temporary one
+	tmp, err := ioutil.TempFile(*textfileDir, "."+name+".")
+	if err != nil {
+		log.Printf("Couldn't write textfile: %s", err)
+		return
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		log.Printf("Couldn't write textfile: %s", err)
+		return
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		log.Printf("Couldn't write textfile: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Printf("Couldn't write textfile: %s", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Printf("Couldn't write textfile: %s", err)
+		return
+	}
+	if textfileWritten != "" && textfileWritten != path {
+		if err := os.Remove(textfileWritten); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove old textfile: %s", err)
+		}
+	}
+	textfileWritten = path
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// thresholdPerMetric holds the <metric>_warning_threshold and
+	// <metric>_critical_threshold gauges, keyed by their names.
+	thresholdPerMetric = map[string]*prometheus.GaugeVec{}
+
+	// thresholdBounds holds the bounds of every threshold as munin
+	// declares them, keyed by gauge name and field, so they can be
+	// converted again when the transforms change.
+	thresholdBounds = map[string]threshold{}
+)
+
+// threshold is the warning or critical threshold of a field.
+type threshold struct {
+	name, metricName, graph, field string
+	bounds                         map[string]float64
+}
+
+// registerThresholds exports the warning and critical thresholds of field
+// in graph, "<max>", "<min>:", ":<max>" or "<min>:<max>", as gauges named
+// after the field's metric with a bound label, min or max, so alerting
+// rules can compare values against the thresholds munin checks. They're
+// converted like the field's values, except for its cdef.
+func registerThresholds(metricName, plugin, graph, field string, config map[string]string, labels map[string]string) {
+	for _, level := range []string{"warning", "critical"} {
+		spec, ok := config[level]
+		if !ok {
+			continue
+		}
+		bounds, ok := parseThreshold(spec)
+		if !ok {
+			log.Printf("Ignoring invalid %s threshold %q of %s", level, spec, metricName)
+			continue
+		}
+		name := metricName + "_" + level + "_threshold"
+		gv, ok := thresholdPerMetric[name]
+		if !ok {
+			help := "The " + level + " threshold munin checks " + metricName + " against."
+			gv = prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name:        name,
+					Help:        help,
+					ConstLabels: labels,
+				},
+				[]string{"hostname", "graphname", "muninlabel", "bound"},
+			)
+			if err := registry.Register(gv); err != nil {
+				log.Printf("Couldn't register %s: %s", name, err)
+				continue
+			}
+			thresholdPerMetric[name] = gv
+			catalogField := field
+			if pluginNaming(plugin) == "plugin" {
+				catalogField = ""
+			}
+			addCatalogEntry(name, "gauge", help, labels, plugin, catalogField, graphVLabels[graph], "bound")
+		}
+		t := threshold{name, metricName, graph, field, bounds}
+		thresholdBounds[name+"\xff"+field] = t
+		t.export()
+	}
+}
+
+// export sets the gauges of t to its bounds, converted like the field's
+// values.
+func (t threshold) export() {
+	gv, ok := thresholdPerMetric[t.name]
+	if !ok {
+		return
+	}
+	for bound, value := range t.bounds {
+		if transform, ok := fieldTransforms[t.metricName+"\xff"+t.field]; ok {
+			value = value*transform.multiply + transform.offset
+		}
+		value *= unitFactor(t.graph)
+		for _, identity := range seriesIdentities(t.graph) {
+			gv.WithLabelValues(identity, t.graph, t.field, bound).Set(value)
+		}
+	}
+}
+
+// refreshThresholds converts the bounds of all thresholds again after the
+// transforms were refreshed, so the gauges don't keep showing the limits
+// of the previous configuration. The caller must hold scrapeMu.
+func refreshThresholds() {
+	for _, t := range thresholdBounds {
+		t.export()
+	}
+}
+
+// parseThreshold returns the bounds set by a munin threshold.
+func parseThreshold(spec string) (map[string]float64, bool) {
+	min, max := "", strings.TrimSpace(spec)
+	if i := strings.Index(spec, ":"); i >= 0 {
+		min, max = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	}
+	bounds := map[string]float64{}
+	for bound, value := range map[string]string{"min": min, "max": max} {
+		if value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, false
+		}
+		bounds[bound] = v
+	}
+	return bounds, len(bounds) > 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	muninTLS                   = flag.Bool("munin.tls", false, "Use STARTTLS to encrypt the connection to munin-node.")
+	muninTLSCAFile             = flag.String("munin.tls.ca-file", "", "CA certificate file to verify munin-node against. Defaults to the system roots.")
+	muninTLSInsecureSkipVerify = flag.Bool("munin.tls.insecure-skip-verify", false, "Don't verify the certificate presented by munin-node.")
+	muninTLSServerName         = flag.String("munin.tls.server-name", "", "Name to verify the certificate presented by munin-node against. Defaults to the host of -muninAddress, required for unix:// addresses.")
+	muninTLSCertFile           = flag.String("munin.tls.cert-file", "", "Client certificate file to present to munin-node.")
+	muninTLSKeyFile            = flag.String("munin.tls.key-file", "", "Private key file for the client certificate.")
+	muninTLSReloadInterval     = flag.Duration("munin.tls.reload-interval", time.Minute, "How often to check the client certificate files for changes.")
+	clientCert                 *certReloader
+	clientCertOnce             sync.Once // connections are set up concurrently
+)
+
+func muninTLSConfig() (config *tls.Config, err error) {
+	config = &tls.Config{
+		InsecureSkipVerify: *muninTLSInsecureSkipVerify,
+	}
+	if *muninTLSServerName != "" {
+		config.ServerName = *muninTLSServerName
+	} else if host, _, err := net.SplitHostPort(muninTarget()); err == nil {
+		if i := strings.Index(host, "%"); i >= 0 { // zones aren't part of names
+			host = host[:i]
+		}
+		config.ServerName = host
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		return nil, fmt.Errorf("No name to verify the certificate of munin-node at %s against, set -munin.tls.server-name", muninTarget())
+	}
+	if *muninTLSCAFile != "" {
+		pem, err := ioutil.ReadFile(*muninTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", *muninTLSCAFile)
+		}
+	}
+	if *muninTLSCertFile != "" || *muninTLSKeyFile != "" {
+		clientCertOnce.Do(func() {
+			clientCert = &certReloader{certFile: *muninTLSCertFile, keyFile: *muninTLSKeyFile}
+		})
+		if _, err := clientCert.GetClientCertificate(nil); err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = clientCert.GetClientCertificate
+	}
+	return
+}
+
+// certReloader serves the client certificate, reloading it from disk when
+// the files have been modified so rotated certificates are picked up
+// without restarting the exporter.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (c *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && time.Since(c.lastCheck) < *muninTLSReloadInterval {
+		return c.cert, nil
+	}
+	c.lastCheck = time.Now()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		if c.cert != nil {
+			log.Printf("Couldn't stat client certificate, keeping the current one: %s", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			log.Printf("Couldn't reload client certificate, keeping the current one: %s", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil {
+		log.Printf("Reloaded client certificate from %s", c.certFile)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return c.cert, nil
+}
+
+func (c *certReloader) latestModTime() (modTime time.Time, err error) {
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	muninTraceFile   = flag.String("munin.trace-file", "", "File to append every line exchanged with munin-node to, for diagnosing protocol problems. Disabled if empty.")
+	muninTraceBuffer = flag.Int("munin.trace-buffer", 0, "Number of bytes of the latest lines exchanged with munin-node to keep for /debug/trace. 0 disables it.")
+
+	protocolTrace = &tracer{}
+)
+
+// tracer receives the lines traced by munin clients, prefixes them with the
+// time and keeps them in a file and/or a bounded buffer.
+type tracer struct {
+	mu    sync.Mutex
+	file  *os.File
+	lines []string
+	size  int
+}
+
+// enabled reports whether tracing is configured. It opens the trace file on
+// first use.
+func (t *tracer) enabled() bool {
+	if *muninTraceFile == "" && *muninTraceBuffer <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *muninTraceFile != "" && t.file == nil {
+		f, err := os.OpenFile(*muninTraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Printf("Couldn't open trace file: %s", err)
+			return *muninTraceBuffer > 0
+		}
+		t.file = f
+	}
+	return true
+}
+
+func (t *tracer) Write(p []byte) (int, error) {
+	line := time.Now().Format(time.RFC3339Nano) + " " + string(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file != nil {
+		if _, err := t.file.WriteString(line); err != nil {
+			log.Printf("Couldn't write trace: %s", err)
+		}
+	}
+	if *muninTraceBuffer > 0 {
+		t.lines = append(t.lines, line)
+		t.size += len(line)
+		for t.size > *muninTraceBuffer && len(t.lines) > 0 {
+			t.size -= len(t.lines[0])
+			t.lines = t.lines[1:]
+		}
+	}
+	return len(p), nil
+}
+
+// serveTrace emits the buffered trace, oldest line first.
+func serveTrace(w http.ResponseWriter, r *http.Request) {
+	protocolTrace.mu.Lock()
+	lines := append([]string(nil), protocolTrace.lines...)
+	protocolTrace.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line)); err != nil {
+			log.Printf("Couldn't write trace response: %s", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// transform converts the values of a field to a more convenient unit.
+type transform struct {
+	multiply float64
+	offset   float64
+}
+
+var (
+	// fieldTransforms holds the transforms of fields, keyed by metric and
+	// field.
+	fieldTransforms = map[string]transform{}
+
+	// transformFields holds the metric, plugin and field of all registered
+	// fields, keyed like fieldTransforms, so their transforms can be
+	// looked up again when the configuration changes.
+	transformFields = map[string][3]string{}
+)
+
+// registerTransform enables the transform configured for field of plugin
+// with "transform.<plugin glob>.<field glob> = <multiply> [<offset>]",
+// e.g. "transform.temp_*.* = 0.5556 -17.78" for °F to °C.
+func registerTransform(metricName, plugin, field string) {
+	transformFields[metricName+"\xff"+field] = [3]string{metricName, plugin, field}
+	delete(fieldTransforms, metricName+"\xff"+field) // removed from the config
+	value, ok := pluginSetting("transform", plugin, field)
+	if !ok {
+		return
+	}
+	t, ok := parseTransform(value)
+	if !ok {
+		log.Printf("Ignoring invalid transform %q for %s", value, metricName)
+		return
+	}
+	fieldTransforms[metricName+"\xff"+field] = t
+}
+
+func parseTransform(value string) (t transform, ok bool) {
+	parts := strings.Fields(value)
+	if len(parts) < 1 || len(parts) > 2 {
+		return
+	}
+	var err error
+	if t.multiply, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return
+	}
+	if len(parts) == 2 {
+		if t.offset, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return
+		}
+	}
+	return t, true
+}
+
+// refreshTransforms looks up the transforms of all registered fields again
+// after the configuration changed, so added, changed and removed
+// transforms apply to plugins registered already. The caller must hold
+// scrapeMu.
+func refreshTransforms() {
+	for _, f := range transformFields {
+		registerTransform(f[0], f[1], f[2])
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	transportBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "munin_transport_bytes_total",
+			Help: "Bytes exchanged with munin-node, by direction: read or written.",
+		},
+		[]string{"hostname", "direction"},
+	)
+	connectDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "munin_connect_duration_seconds",
+			Help:    "Time taken to establish a munin session, from dialing up to the banner and TLS handshake.",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10},
+		},
+		[]string{"hostname"},
+	)
+	commandDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "munin_command_duration_seconds",
+			Help:    "Round-trip time of munin commands, from sending the command to reading the complete response.",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+		},
+		[]string{"hostname", "command"},
+	)
+)
+
+func init() {
+	registry.MustRegister(transportBytes, connectDuration, commandDuration)
+}
+
+// countingConn counts the bytes read from and written to munin-node.
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	transportBytes.WithLabelValues(nodeHostname(), "read").Add(float64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	transportBytes.WithLabelValues(nodeHostname(), "written").Add(float64(n))
+	return n, err
+}
+
+func observeCommand(cmd string, start time.Time) {
+	commandDuration.WithLabelValues(nodeHostname(), cmd).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// scrapeMu keeps scrapes, whether regular, on demand or triggered, from
+// running at the same time.
+var scrapeMu sync.Mutex
+
+// handleScrapeSignal runs a scrape of all plugins on SIGUSR2.
+func handleScrapeSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	for range signals {
+		log.Printf("Received SIGUSR2, scraping now")
+		if err := scrapeNow(nil); err != nil {
+			log.Printf("Triggered scrape failed: %s", err)
+		}
+	}
+}
+
+// serveScrape runs a scrape on POST, of the plugins matching the plugin
+// glob parameter or of all plugins without it.
+func serveScrape(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Use POST to trigger a scrape", http.StatusMethodNotAllowed)
+		return
+	}
+	var patterns []string
+	if plugin := r.URL.Query().Get("plugin"); plugin != "" {
+		patterns = []string{plugin}
+	}
+	if err := scrapeNow(patterns); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "Scraped")
+}
+
+// scrapeNow scrapes the plugins matching patterns, or all plugins if
+// patterns is nil, out of band. They are fetched even if their schedule or
+// interval says otherwise.
+func scrapeNow(patterns []string) error {
+	if err := ensureRegistered(); err != nil {
+		return err
+	}
+	scrapeMu.Lock()
+	for _, plugin := range graphs {
+		if patterns == nil || matchesAny(patterns, plugin) {
+			delete(lastScheduledFetch, plugin)
+			delete(lastIntervalFetch, plugin)
+		}
+	}
+	scrapeMu.Unlock()
+	return scrapePlugins(context.Background(), patterns)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+)
+
+var muninSeriesTTL = flag.Int("munin.series-ttl", 0, "Number of fetches of a plugin after which a field not updated by them stops being exported, instead of freezing its last value. Overridden per field with \"ttl.<plugin glob>.<field glob> = <fetches>\". 0 exports the last value forever.")
+
+// ttlState tracks whether a series with a TTL is still updated.
+type ttlState struct {
+	plugin, name, graph, field string
+	ttl                        int
+	missed                     int  // fetches of the plugin not updating the series
+	fresh                      bool // updated by the current fetch
+}
+
+var ttlSeries = map[string]*ttlState{} // by series
+
+// seriesTTL returns the TTL of field of plugin in fetches, 0 if none.
+func seriesTTL(plugin, field string) int {
+	value, ok := pluginSetting("ttl", plugin, field)
+	if !ok {
+		return *muninSeriesTTL
+	}
+	ttl, err := strconv.Atoi(value)
+	if err != nil || ttl < 0 {
+		log.Printf("Ignoring invalid TTL %q for %s", value, plugin)
+		return *muninSeriesTTL
+	}
+	return ttl
+}
+
+// touchSeries records that the series of s was updated.
+func touchSeries(s Sample) {
+	key := s.Name + "\xff" + s.Graph + "\xff" + s.Field
+	state, ok := ttlSeries[key]
+	if !ok {
+		ttl := seriesTTL(s.Plugin, s.Field)
+		if ttl <= 0 {
+			return
+		}
+		state = &ttlState{plugin: s.Plugin, name: s.Name, graph: s.Graph, field: s.Field, ttl: ttl}
+		ttlSeries[key] = state
+	}
+	state.fresh = true
+}
+
+// expireSeries removes the series of the plugins in fetched that weren't
+// updated by their last TTL fetches, whether the plugin failed or no longer
+// sends the field.
+func expireSeries(fetched map[string]fetchResult) {
+	for key, state := range ttlSeries {
+		if _, ok := fetched[state.plugin]; !ok {
+			continue // not due, or not fetched after an earlier failure
+		}
+		if state.fresh {
+			state.fresh, state.missed = false, 0
+			continue
+		}
+		state.missed++
+		if state.missed >= state.ttl {
+			log.Printf("%s of %s not updated by %d fetches, no longer exporting it", state.field, state.graph, state.missed)
+			deleteSeries(state.name, state.graph, state.field)
+			delete(ttlSeries, key)
+		}
+	}
+}
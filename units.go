@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+)
+
+var muninUnitSuffixes = flag.Bool("munin.unit-suffixes", false, "Append the unit inferred from graph_vlabel to metric names, e.g. _bytes, _seconds or _ratio, and convert values to that unit, e.g. bits to bytes and percentages to ratios.")
+
+// unit is a Prometheus base unit along with the factor converting values
+// of a munin unit to it.
+type unit struct {
+	suffix string
+	factor float64
+}
+
+// vlabelUnits maps the words of graph_vlabel naming a unit to the unit.
+var vlabelUnits = map[string]unit{
+	"byte":         {"_bytes", 1},
+	"bytes":        {"_bytes", 1},
+	"bit":          {"_bytes", 1.0 / 8},
+	"bits":         {"_bytes", 1.0 / 8},
+	"%":            {"_ratio", 0.01},
+	"percent":      {"_ratio", 0.01},
+	"sec":          {"_seconds", 1},
+	"secs":         {"_seconds", 1},
+	"second":       {"_seconds", 1},
+	"seconds":      {"_seconds", 1},
+	"ms":           {"_seconds", 1e-3},
+	"millisecond":  {"_seconds", 1e-3},
+	"milliseconds": {"_seconds", 1e-3},
+	"µs":           {"_seconds", 1e-6},
+	"us":           {"_seconds", 1e-6},
+	"microseconds": {"_seconds", 1e-6},
+	"°c":           {"_celsius", 1},
+	"celsius":      {"_celsius", 1},
+	"volt":         {"_volts", 1},
+	"volts":        {"_volts", 1},
+	"amps":         {"_amperes", 1},
+	"amperes":      {"_amperes", 1},
+	"watt":         {"_watts", 1},
+	"watts":        {"_watts", 1},
+	"hz":           {"_hertz", 1},
+	"hertz":        {"_hertz", 1},
+}
+
+// timeUnits are the denominators of vlabels making them rates, as in
+// "bytes per ${graph_period}" or "ops/s".
+var timeUnits = map[string]bool{
+	"s": true, "sec": true, "secs": true, "second": true, "seconds": true,
+	"min": true, "minute": true, "minutes": true, "hour": true, "hours": true,
+	"day": true, "days": true, "graph_period": true,
+}
+
+var (
+	vlabelWord = regexp.MustCompile(`[a-zµ°%_]+|/`)
+
+	// graphUnits holds the units inferred for graphs.
+	graphUnits = map[string]unit{}
+)
+
+// registerUnit infers the unit of graph from the first word of its
+// vlabel naming one, e.g. "bytes used". Denominators, the words following
+// "per" or "/", are skipped, and vlabels of rates, with a time as their
+// denominator as in "bytes per second", get no unit: their values aren't
+// in the unit itself.
+func registerUnit(graph, vlabel string) {
+	delete(graphUnits, graph)
+	if !*muninUnitSuffixes {
+		return
+	}
+	words := vlabelWord.FindAllString(strings.ToLower(vlabel), -1)
+	var u unit
+	found := false
+	for i := 0; i < len(words); i++ {
+		if words[i] == "per" || words[i] == "/" {
+			if i+1 < len(words) && timeUnits[words[i+1]] {
+				return // a rate
+			}
+			i++
+			continue
+		}
+		if wu, ok := vlabelUnits[words[i]]; ok && !found {
+			u, found = wu, true
+		}
+	}
+	if found {
+		graphUnits[graph] = u
+	}
+}
+
+// withUnitSuffix appends the unit of graph to name, unless it ends with it
+// already.
+func withUnitSuffix(graph, name string) string {
+	suffix := graphUnits[graph].suffix
+	if strings.HasSuffix(name, suffix) {
+		return name
+	}
+	return name + suffix
+}
+
+// unitFactor returns the factor converting values of graph to its unit.
+func unitFactor(graph string) float64 {
+	if u, ok := graphUnits[graph]; ok {
+		return u.factor
+	}
+	return 1
+}
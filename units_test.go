@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRegisterUnit(t *testing.T) {
+	*muninUnitSuffixes = true
+	defer func() { *muninUnitSuffixes = false }()
+	for _, tt := range []struct {
+		vlabel string
+		suffix string
+		factor float64
+	}{
+		{"bytes", "_bytes", 1},
+		{"Used bits", "_bytes", 1.0 / 8},
+		{"%", "_ratio", 0.01},
+		{"Response time in ms", "_seconds", 1e-3},
+		{"°C", "_celsius", 1},
+		{"bytes per second", "", 1},
+		{"bytes/s", "", 1},
+		{"bits in (-) / out (+) per ${graph_period}", "", 1},
+		{"requests per ${graph_period}", "", 1},
+		{"s", "", 1},
+		{"entries per user", "", 1},
+		{"seconds per query", "_seconds", 1},
+	} {
+		registerUnit("g", tt.vlabel)
+		u := graphUnits["g"]
+		if u.suffix != tt.suffix || unitFactor("g") != tt.factor {
+			t.Errorf("vlabel %q gives %q, %v, want %q, %v", tt.vlabel, u.suffix, unitFactor("g"), tt.suffix, tt.factor)
+		}
+	}
+	delete(graphUnits, "g")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+)
+
+var muninUnknownValues = flag.String("munin.unknown-values", "skip", "How to export fields a plugin reports as U (unknown): skip keeps the previous value, nan exports NaN for gauges, stale removes the series so Prometheus marks it stale.")
+
+// exportUnknown applies -munin.unknown-values to the field of graph that
+// munin reported as unknown. It returns true if the NaN value is to be
+// exported.
+func exportUnknown(graph, field string) bool {
+	name := metricNameFor(graph, field)
+	switch *muninUnknownValues {
+	case "nan":
+		_, isGauge := gaugePerMetric[name]
+		return isGauge // a NaN would stick to counters for good
+	case "stale":
+		deleteSeries(name, graph, field)
+	}
+	return false
+}
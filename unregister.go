@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// unregisterPlugin removes the metrics generated from plugin, so a plugin
+// that was removed, or whose config changed, stops exporting its last
+// values. A changed config can then be registered afresh.
+func unregisterPlugin(plugin string) {
+	catalogMu.Lock()
+	var names []string
+	for name, entry := range catalog {
+		if entry.Plugin == plugin {
+			names = append(names, name)
+			delete(catalog, name)
+		}
+	}
+	catalogMu.Unlock()
+
+	for _, name := range names {
+		if gv, ok := gaugePerMetric[name]; ok {
+			registry.Unregister(gv)
+			delete(gaugePerMetric, name)
+		}
+		if cv, ok := counterPerMetric[name]; ok {
+			registry.Unregister(cv)
+			delete(counterPerMetric, name)
+		}
+		if tv, ok := thresholdPerMetric[name]; ok {
+			registry.Unregister(tv)
+			delete(thresholdPerMetric, name)
+		}
+		if raw, ok := rawPerMetric[name]; ok {
+			registry.Unregister(raw)
+			delete(rawPerMetric, name)
+			delete(emaAlpha, name)
+		}
+		prefix := name + "\xff"
+		for key := range emaValue {
+			if strings.HasPrefix(key, prefix) {
+				delete(emaValue, key)
+			}
+		}
+		for key := range rollupValues {
+			if strings.HasPrefix(key, prefix) {
+				delete(rollupValues, key)
+			}
+		}
+		for key := range counterSamples {
+			if strings.HasPrefix(key, prefix) {
+				delete(counterSamples, key)
+			}
+		}
+		for key := range rateMin {
+			if strings.HasPrefix(key, prefix) {
+				delete(rateMin, key)
+			}
+		}
+		for key := range fieldTransforms {
+			if strings.HasPrefix(key, prefix) {
+				delete(fieldTransforms, key)
+			}
+		}
+		for key := range transformFields {
+			if strings.HasPrefix(key, prefix) {
+				delete(transformFields, key)
+			}
+		}
+		for key := range counterMax {
+			if strings.HasPrefix(key, prefix) {
+				delete(counterMax, key)
+			}
+		}
+		for key := range thresholdBounds {
+			if strings.HasPrefix(key, prefix) {
+				delete(thresholdBounds, key)
+			}
+		}
+		for key := range zeroSince {
+			if strings.HasPrefix(key, prefix) {
+				delete(zeroSince, key)
+			}
+		}
+	}
+
+	for graph := range expectedFields[plugin] {
+		delete(graphCategories, graph)
+		delete(graphVLabels, graph)
+		delete(graphHostNames, graph)
+		delete(graphCDEFs, graph)
+		delete(graphUnits, graph)
+		for key := range familyNames {
+			if strings.HasPrefix(key, graph+"\xff") {
+				delete(familyNames, key)
+			}
+		}
+		fieldsMissing.DeleteLabelValues(nodeHostname(), plugin, graph)
+		fieldsUnexpected.DeleteLabelValues(nodeHostname(), plugin, graph)
+	}
+	for key, state := range ttlSeries {
+		if state.plugin == plugin {
+			delete(ttlSeries, key)
+		}
+	}
+	for i, name := range pendingPlugins {
+		if name == plugin {
+			pendingPlugins = append(pendingPlugins[:i], pendingPlugins[i+1:]...)
+			break
+		}
+	}
+	delete(expectedFields, plugin)
+	delete(configFingerprints, plugin)
+	delete(pluginCategories, plugin)
+	delete(pluginStatus, plugin)
+	delete(dirtyFetched, plugin)
+	delete(lastScheduledFetch, plugin)
+	delete(lastIntervalFetch, plugin)
+	lastFetchesMu.Lock()
+	delete(lastFetches, plugin)
+	lastFetchesMu.Unlock()
+	log.Printf("Unregistered %d metrics of %s", len(names), plugin)
+}
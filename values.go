@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// convertedSample is a sample on its way through convertValues.
+type convertedSample struct {
+	Sample
+	seconds float64 // since the last value of a counter, 0 if none
+}
+
+// convertValues turns the values munin sent at now in samples, those of
+// one fetch of a plugin, into the values exported, and returns the samples
+// to export. Gauges are exported with their value, DERIVE fields exported
+// as rates with their rate per second from their second value on, and
+// counters with their increase since the last fetch. Wraps and resets are
+// detected on munin's values. cdefs are evaluated like munin does, with
+// the rates of COUNTER and DERIVE fields, so a counter with a cdef goes up
+// by the rate the cdef computes times the seconds since the last fetch,
+// starting at 0. Transforms apply to the result, except for their offset
+// to increases. Samples with a timestamp keep munin's absolute values,
+// which their cdefs and transforms apply to directly.
+func convertValues(samples []Sample, now time.Time) []Sample {
+	var converted []convertedSample
+	values := map[string]map[string]float64{} // the values cdefs see, by graph
+	for _, s := range samples {
+		c := convertedSample{Sample: s}
+		seen := s.Value // what munin graphs, before the cdef
+		_, isCounter := counterPerMetric[s.Name]
+		min, isRate := rateMin[s.Name+"\xff"+s.Field]
+		keep := true
+		if s.Timestamp.IsZero() && !math.IsNaN(s.Value) {
+			switch {
+			case isCounter:
+				c.Value, c.seconds = counterIncrease(s.Name, s.Graph, s.Field, s.Value, now)
+				seen = math.NaN()
+				if c.seconds > 0 {
+					seen = c.Value / c.seconds
+				}
+			case isRate:
+				c.Value, keep = rate(s.Name, s.Graph, s.Field, s.Value, min, now)
+				seen = c.Value
+				if !keep {
+					seen = math.NaN()
+				}
+			}
+		}
+		if values[s.Graph] == nil {
+			values[s.Graph] = map[string]float64{}
+		}
+		values[s.Graph][s.Field] = seen
+		if keep { // no rate before the second value
+			converted = append(converted, c)
+		}
+	}
+
+	samples = samples[:0]
+	for _, c := range converted {
+		_, isCounter := counterPerMetric[c.Name]
+		isIncrease := isCounter && c.Timestamp.IsZero()
+		if cdef, ok := graphCDEFs[c.Graph][c.Field]; ok {
+			value := cdef.Eval(values[c.Graph])
+			switch {
+			case !isIncrease:
+				c.Value = value
+			case math.IsNaN(c.Value):
+			case c.seconds == 0 || math.IsNaN(value):
+				c.Value = 0
+			default:
+				c.Value = math.Max(value, 0) * c.seconds
+			}
+		}
+		if t, ok := fieldTransforms[c.Name+"\xff"+c.Field]; ok {
+			c.Value *= t.multiply
+			if !isIncrease { // increases have no offset
+				c.Value += t.offset
+			}
+		}
+		c.Value *= unitFactor(c.Graph)
+		samples = append(samples, c.Sample)
+	}
+	return samples
+}
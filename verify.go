@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+// verify runs one scrape through the exporter pipeline, then fetches every
+// plugin again over a separate munin session and reports values that
+// differ or are missing from the exporter. It returns the exit code: 0 if
+// everything matched, 1 otherwise.
+func verify() int {
+	if err := fetchMetrics(); err != nil {
+		log.Printf("Error occured when trying to fetch metrics: %s", err)
+		return 1
+	}
+	exported, err := exportedValues()
+	if err != nil {
+		log.Printf("Couldn't gather exported metrics: %s", err)
+		return 1
+	}
+
+	direct, err := newClient()
+	if err != nil {
+		log.Printf("Couldn't open verification session: %s", err)
+		return 1
+	}
+	defer direct.Close()
+
+	// ask for the same capabilities, so multigraph plugins answer alike
+	direct.Caps(context.Background(), wantedCaps...)
+
+	var problems []string
+	checked := 0
+	for _, plugin := range graphs {
+		lines, err := direct.Lines(context.Background(), "fetch "+plugin)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: direct fetch failed: %s", plugin, err))
+			continue
+		}
+		for _, section := range munin.SplitMultigraph(plugin, lines) {
+			for _, line := range section.Lines {
+				v, err := munin.ParseFetchLine(line)
+				if err != nil || v.Unknown {
+					continue
+				}
+				checked++
+				key := section.Graph + "." + v.Field
+				got, ok := exported[key]
+				switch {
+				case !ok:
+					problems = append(problems, fmt.Sprintf("%s: missing from exporter (munin: %g)", key, v.Value))
+				case !closeEnough(got, v.Value):
+					problems = append(problems, fmt.Sprintf("%s: exporter %g, munin %g", key, got, v.Value))
+				}
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d values checked, %d discrepancies\n", checked, len(problems))
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// exportedValues returns the exported value of every munin field, keyed by
+// graph.field.
+func exportedValues() (map[string]float64, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var graph, field string
+			for _, label := range m.GetLabel() {
+				switch label.GetName() {
+				case "graphname":
+					graph = label.GetValue()
+				case "muninlabel":
+					field = label.GetValue()
+				}
+			}
+			if graph == "" || field == "" {
+				continue
+			}
+			values[graph+"."+field] = metricValue(family.GetType(), m)
+		}
+	}
+	return values, nil
+}
+
+func metricValue(t dto.MetricType, m *dto.Metric) float64 {
+	if t == dto.MetricType_COUNTER {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+// closeEnough compares two values allowing for float rounding. Values that
+// genuinely changed between the two fetches are reported, so gauges of busy
+// plugins may show up as discrepancies.
+func closeEnough(a, b float64) bool {
+	if a == b || (math.IsNaN(a) && math.IsNaN(b)) {
+		return true
+	}
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
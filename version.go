@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pvdh/munin_exporter/munin"
+)
+
+var (
+	nodeVersion string // guarded by nodeMu
+
+	nodeInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "munin_node_info",
+			Help: "Version of munin-node, always 1.",
+		},
+		[]string{"hostname", "version"},
+	)
+)
+
+func init() {
+	registry.MustRegister(nodeInfo)
+}
+
+// queryVersion asks munin-node for its version on a new connection, so
+// upgrades show up without restarting the exporter. It returns "" if
+// munin-node doesn't tell.
+func queryVersion(c *munin.Client) string {
+	version, err := c.Version(context.Background())
+	if err != nil {
+		log.Printf("Couldn't get munin-node version: %s", err)
+		return ""
+	}
+	return version
+}
+
+// updateNodeInfo exports version, unless unknown, as munin_node_info.
+func updateNodeInfo(version string) {
+	if version == "" {
+		return
+	}
+	nodeInfo.Reset()
+	for _, identity := range identities() {
+		nodeInfo.WithLabelValues(identity, version).Set(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	viewHandlers   = map[string]http.Handler{} // by view setting
+	viewHandlersMu sync.Mutex
+)
+
+// matchesAny reports whether plugin matches one of the globs in patterns.
+func matchesAny(patterns []string, plugin string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, plugin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// serveView serves the metrics of the plugins of a view, configured with
+// "view.<name> = <plugin glob>...", e.g. "view.slow = smart_* apt", on
+// <listeningPath>/<name>. When scrapes fetch from munin-node, only the
+// plugins of the view are fetched, so every view can be scraped at an
+// interval of its own.
+func serveView(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, *listeningPath+"/")
+	spec, ok := setting("view." + name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	viewHandlersMu.Lock()
+	handler, ok := viewHandlers[spec]
+	if !ok {
+		handler = newViewHandler(strings.Fields(spec))
+		viewHandlers[spec] = handler
+	}
+	viewHandlersMu.Unlock()
+	handler.ServeHTTP(w, r)
+}
+
+func newViewHandler(patterns []string) http.Handler {
+	view := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := registry.Gather()
+		return viewFamilies(patterns, families), err
+	})
+	if !fetchOnScrape() {
+		return handlerFor(view)
+	}
+	return onDemandHandler(patterns, view)
+}
+
+// viewFamilies returns the families generated from plugins matching
+// patterns.
+func viewFamilies(patterns []string, families []*dto.MetricFamily) []*dto.MetricFamily {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	var matching []*dto.MetricFamily
+	for _, family := range families {
+		entry, ok := catalog[family.GetName()]
+		if ok && matchesAny(patterns, entry.Plugin) {
+			matching = append(matching, family)
+		}
+	}
+	return matching
+}
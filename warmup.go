@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	webUnavailableOnError = flag.Bool("web.unavailable-on-error", false, "Answer 503 on the metrics path if the last scrape of all plugins failed, instead of serving the values fetched before, so Prometheus records up 0 instead of stale values.")
+	webWarmUp             = flag.String("web.warm-up", "meta", "What the metrics path serves until the first scrape of all plugins succeeded: meta to serve only the exporter's own metrics with munin_up 0, unavailable to answer 503 with a Retry-After header.")
+
+	muninUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "munin_up",
+			Help: "Whether the last scrape of all plugins succeeded.",
+		},
+	)
+
+	// warmedUp is 1 once a scrape of all plugins succeeded.
+	warmedUp int32
+)
+
+func init() {
+	registry.MustRegister(muninUp)
+}
+
+// recordFullScrape records the outcome of a scrape of all plugins.
+func recordFullScrape(err error) {
+	if err != nil {
+		muninUp.Set(0)
+		atomic.StoreInt32(&lastScrapeOK, 0)
+		return
+	}
+	muninUp.Set(1)
+	atomic.StoreInt32(&lastScrapeOK, 1)
+	atomic.StoreInt32(&warmedUp, 1)
+}
+
+// warmUp scrapes all plugins once the metrics are registered, when scrapes
+// fetch from munin-node, so the exporter gets ready without waiting for
+// Prometheus to scrape it, which it may not do before it's ready.
+func warmUp() {
+	<-registeredCh
+	if err := scrape(); err != nil {
+		log.Printf("Warm-up scrape failed: %s", err)
+	}
+}
+
+// unavailableWhileWarmingUp answers 503 with a Retry-After header if no
+// scrape of all plugins succeeded yet and -web.warm-up is unavailable.
+func unavailableWhileWarmingUp(w http.ResponseWriter) bool {
+	if atomic.LoadInt32(&warmedUp) == 1 || *webWarmUp != "unavailable" {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(*muninScrapeInterval))
+	http.Error(w, "Waiting for the first scrape of munin-node", http.StatusServiceUnavailable)
+	return true
+}
+
+// unavailableAfterError answers 503 if the last scrape of all plugins
+// failed and -web.unavailable-on-error is set.
+func unavailableAfterError(w http.ResponseWriter) bool {
+	if !*webUnavailableOnError || atomic.LoadInt32(&lastScrapeOK) == 1 {
+		return false
+	}
+	http.Error(w, "Last scrape of munin-node failed", http.StatusServiceUnavailable)
+	return true
+}
+
+// warmUpGate serves the metrics with h once the first scrape of all plugins
+// succeeded. Until then, it serves the exporter's own metrics, or 503 with
+// -web.warm-up unavailable, so scrapes during startup don't see a partial
+// set of plugin metrics.
+func warmUpGate(h http.Handler) http.Handler {
+	meta := handlerFor(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := registry.Gather()
+		return metaFamilies(families), err
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case atomic.LoadInt32(&warmedUp) == 1:
+			if !unavailableAfterError(w) {
+				h.ServeHTTP(w, r)
+			}
+		case unavailableWhileWarmingUp(w):
+		default:
+			meta.ServeHTTP(w, r)
+		}
+	})
+}
+
+// metaFamilies returns the families not generated from plugins.
+func metaFamilies(families []*dto.MetricFamily) []*dto.MetricFamily {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	var meta []*dto.MetricFamily
+	for _, family := range families {
+		if _, ok := catalog[family.GetName()]; !ok {
+			meta = append(meta, family)
+		}
+	}
+	return meta
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	suppressZeroAfter = flag.Duration("suppress-zero-after", 0, "Stop exporting series whose munin value has been exactly 0 for this long, resuming as soon as it changes. 0 disables it.")
+
+	// zeroSince holds since when series have been 0, by metric, graph and
+	// field.
+	zeroSince = map[string]time.Time{}
+)
+
+// zeroSeries returns the series of samples, as fetched from munin, that
+// have been 0 for longer than -suppress-zero-after, by metric, graph and
+// field. Counters are judged by munin's value as well, not by their
+// increase, so a counter that holds still isn't taken for 0.
+func zeroSeries(samples []Sample) map[string]bool {
+	if *suppressZeroAfter <= 0 {
+		return nil
+	}
+	zero := map[string]bool{}
+	for _, s := range samples {
+		if !s.Timestamp.IsZero() {
+			continue
+		}
+		key := s.Name + "\xff" + s.Graph + "\xff" + s.Field
+		if s.Value != 0 {
+			delete(zeroSince, key)
+			continue
+		}
+		since, ok := zeroSince[key]
+		if !ok {
+			zeroSince[key] = time.Now()
+			continue
+		}
+		if time.Since(since) >= *suppressZeroAfter {
+			zero[key] = true
+		}
+	}
+	return zero
+}
+
+// suppressZero leaves out the converted samples of the series in zero, as
+// returned by zeroSeries, and removes them from the exposition until their
+// value changes. Their counter state is kept, so they resume with the
+// increase since their last fetch.
+func suppressZero(samples []Sample, zero map[string]bool) []Sample {
+	if len(zero) == 0 {
+		return samples
+	}
+	kept := samples[:0]
+	for _, s := range samples {
+		if zero[s.Name+"\xff"+s.Graph+"\xff"+s.Field] {
+			hideSeries(s.Name, s.Graph, s.Field)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}